@@ -0,0 +1,227 @@
+package fxconsul
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tacjlee/common-sdk/packages/fxcontext"
+)
+
+const redactedValue = "******"
+
+// AdminAuthorizer decides whether the caller may use the admin endpoints; write is true for actions
+// that change the state of the client (refresh, reload)
+type AdminAuthorizer func(ctx *gin.Context, write bool) bool
+
+// AdminSetting is a setting as shown by the admin endpoints
+type AdminSetting struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Source   string `json:"source"` // Consul layer, "snapshot", "env" or "default"
+	Redacted bool   `json:"redacted,omitempty"`
+	Pinned   bool   `json:"pinned,omitempty"` // The latest update was rejected by the schema
+}
+
+// AdminCacheEntry is a cached value with its expiry
+type AdminCacheEntry struct {
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Expired   bool      `json:"expired"`
+}
+
+// AdminStatus describes the connection and watch state of the client
+type AdminStatus struct {
+	ConsulStatus
+	Layers       []string      `json:"layers"`
+	Watching     bool          `json:"watching"`
+	LastChangeAt *time.Time    `json:"lastChangeAt,omitempty"`
+	Metrics      ClientMetrics `json:"metrics"`
+}
+
+// RequireRoles returns an authorizer that allows callers whose role (set on the gin context by the
+// auth middleware) is one of readRoles for reads, and one of writeRoles for refresh and reload
+func RequireRoles(readRoles []string, writeRoles []string) AdminAuthorizer {
+	return func(ctx *gin.Context, write bool) bool {
+		role := fxcontext.GetRole(ctx)
+		allowed := writeRoles
+		if !write {
+			allowed = append(append([]string(nil), readRoles...), writeRoles...)
+		}
+		for _, r := range allowed {
+			if r == role {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// RegisterAdminRoutes adds the configuration admin endpoints to the router group:
+//
+//	GET  /settings  effective settings, secrets redacted
+//	GET  /cache     cache contents with expiry
+//	GET  /status    availability, watch index, last change time and metrics
+//	GET  /schema    schema report (when a schema is configured)
+//	GET  /metrics   metrics in the Prometheus text format
+//	GET  /changes   recent configuration changes (key, since and limit query parameters)
+//	POST /refresh   clear the cache (RefreshCache)
+//	POST /reload    re-read every key from Consul now (ForceReload)
+//
+// Every request is checked with authorize; a nil authorizer denies all requests.
+func (c *ConsulClient) RegisterAdminRoutes(router gin.IRouter, authorize AdminAuthorizer) {
+	read := c.adminGuard(authorize, false)
+	write := c.adminGuard(authorize, true)
+
+	router.GET("/settings", read, func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{"settings": c.AdminSettings()})
+	})
+	router.GET("/cache", read, func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{"ttl": c.cacheTTL.String(), "entries": c.AdminCache()})
+	})
+	router.GET("/status", read, func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, c.AdminStatus())
+	})
+	router.GET("/schema", read, func(ctx *gin.Context) {
+		if c.schema == nil {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "no configuration schema"})
+			return
+		}
+		c.SchemaReportHandler()(ctx)
+	})
+	router.GET("/metrics", read, c.MetricsHandler())
+	router.GET("/changes", read, c.RecentChangesHandler())
+	router.POST("/refresh", write, func(ctx *gin.Context) {
+		c.RefreshCache()
+		c.logger.Printf("Configuration cache refreshed by %s", adminCaller(ctx))
+		ctx.JSON(http.StatusOK, c.AdminStatus())
+	})
+	router.POST("/reload", write, func(ctx *gin.Context) {
+		if err := c.ForceReload(); err != nil {
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		c.logger.Printf("Configuration reloaded by %s", adminCaller(ctx))
+		ctx.JSON(http.StatusOK, c.AdminStatus())
+	})
+}
+
+// AdminSettings returns the effective settings with secrets redacted: every key of the Consul layers
+// (or of the offline snapshot) plus the keys declared in the schema
+func (c *ConsulClient) AdminSettings() []AdminSetting {
+	settings := make(map[string]AdminSetting)
+	if effective, err := c.EffectiveSettings(); err == nil {
+		for key, setting := range effective {
+			settings[key] = AdminSetting{Key: key, Value: setting.Value, Source: setting.Layer}
+		}
+	} else if c.snapshots != nil {
+		c.snapshots.mu.RLock()
+		for key, value := range c.snapshots.values {
+			settings[key] = AdminSetting{Key: key, Value: value, Source: "snapshot"}
+		}
+		c.snapshots.mu.RUnlock()
+	}
+	if c.schema != nil {
+		for _, key := range c.schema.keys {
+			if _, ok := settings[key]; ok {
+				continue
+			}
+			if value := c.GetSetting(key, ""); value != "" {
+				source := "env"
+				if value == c.schema.settings[key].Default {
+					source = "default"
+				}
+				settings[key] = AdminSetting{Key: key, Value: value, Source: source}
+			}
+		}
+	}
+
+	result := make([]AdminSetting, 0, len(settings))
+	for key, setting := range settings {
+		if value, found, pinned := c.lookupPinned(key); pinned {
+			setting.Value, setting.Pinned = value, true
+			if !found {
+				continue
+			}
+		}
+		if c.isSecret(key, setting.Value) {
+			setting.Value, setting.Redacted = redactedValue, true
+		}
+		result = append(result, setting)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// AdminCache returns the cache contents with secrets redacted
+func (c *ConsulClient) AdminCache() []AdminCacheEntry {
+	now := time.Now()
+	c.cacheMu.RLock()
+	result := make([]AdminCacheEntry, 0, len(c.cache))
+	for key, entry := range c.cache {
+		if !entry.found {
+			continue
+		}
+		value := entry.value
+		if c.isSecret(key, value) {
+			value = redactedValue
+		}
+		result = append(result, AdminCacheEntry{Key: key, Value: value, ExpiresAt: entry.expiresAt, Expired: !now.Before(entry.expiresAt)})
+	}
+	c.cacheMu.RUnlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// AdminStatus returns the connection and watch state of the client
+func (c *ConsulClient) AdminStatus() AdminStatus {
+	c.watchMu.Lock()
+	watching := c.watching
+	c.watchMu.Unlock()
+	status := AdminStatus{
+		ConsulStatus: c.Status(),
+		Layers:       c.Layers(),
+		Watching:     watching,
+		Metrics:      c.Metrics(),
+	}
+	if lastChange := c.lastChangeAt.Load(); lastChange > 0 {
+		lastChangeAt := time.Unix(0, lastChange)
+		status.LastChangeAt = &lastChangeAt
+	}
+	return status
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+func (c *ConsulClient) adminGuard(authorize AdminAuthorizer, write bool) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if authorize == nil || !authorize(ctx, write) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// isSecret reports whether the value of the key must not be shown: secret-looking names,
+// settings declared secret in the schema and encrypted values
+func (c *ConsulClient) isSecret(key string, value string) bool {
+	if IsEncrypted(value) || DefaultSecretPattern.MatchString(key) {
+		return true
+	}
+	return c.schema != nil && c.schema.settings[key].Secret
+}
+
+func adminCaller(ctx *gin.Context) string {
+	if username := fxcontext.GetUsername(ctx); username != "" {
+		return username
+	}
+	return ctx.ClientIP()
+}
@@ -0,0 +1,412 @@
+package fxconsul
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// auditBufferSize is the number of recent changes kept in memory for RecentChanges
+const auditBufferSize = 500
+
+// ConfigChangeRecord is an audited configuration change detected by the watcher.
+// Values of secret keys are never stored; with WithAuditHashKey their HMAC-SHA256 still shows whether two
+// values are equal, without it the hashes of redacted records are left empty.
+type ConfigChangeRecord struct {
+	ID           uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Key          string     `gorm:"column:setting_key;size:512;index" json:"key"`
+	Type         ChangeType `gorm:"size:16" json:"type"`
+	OldValue     string     `gorm:"type:text" json:"oldValue"`
+	OldValueHash string     `gorm:"size:64" json:"oldValueHash"`
+	NewValue     string     `gorm:"type:text" json:"newValue"`
+	NewValueHash string     `gorm:"size:64" json:"newValueHash"`
+	Redacted     bool       `json:"redacted"`
+	Rejected     bool       `json:"rejected"` // The update was refused by the schema
+	ModifyIndex  uint64     `json:"modifyIndex"`
+	BasePath     string     `gorm:"size:256" json:"basePath"`
+	Instance     string     `gorm:"size:128" json:"instance"`
+	ChangedAt    time.Time  `gorm:"index" json:"changedAt"`
+}
+
+// AuditQuery filters recent changes. Key matches a single key, or every key under a prefix ending in "/".
+type AuditQuery struct {
+	Key   string
+	Since time.Time
+	Limit int // Defaults to 100
+}
+
+// AuditSink receives every detected change
+type AuditSink interface {
+	Record(records []ConfigChangeRecord) error
+}
+
+// AuditReader is implemented by sinks that can be queried for past changes
+type AuditReader interface {
+	Recent(query AuditQuery) ([]ConfigChangeRecord, error)
+}
+
+// AuditSinkFunc adapts a callback to an AuditSink
+type AuditSinkFunc func(records []ConfigChangeRecord) error
+
+func (f AuditSinkFunc) Record(records []ConfigChangeRecord) error {
+	return f(records)
+}
+
+// WithAuditSink records every change detected by the watcher into the sinks
+func WithAuditSink(sinks ...AuditSink) Option {
+	return func(o *clientOptions) {
+		o.auditSinks = append(o.auditSinks, sinks...)
+	}
+}
+
+// WithAuditHashKey sets the per-deployment key of the HMAC-SHA256 value hashes of audit records.
+// Without a key, values are hashed with plain SHA-256 and the hashes of secret values are omitted.
+func WithAuditHashKey(key []byte) Option {
+	return func(o *clientOptions) {
+		o.auditHashKey = key
+	}
+}
+
+// RecentChanges returns recorded changes, newest first. The first sink implementing AuditReader is
+// queried; otherwise the changes kept in memory since the client started are returned.
+func (c *ConsulClient) RecentChanges(query AuditQuery) ([]ConfigChangeRecord, error) {
+	if query.Limit <= 0 {
+		query.Limit = 100
+	}
+	for _, sink := range c.auditSinks {
+		if reader, ok := sink.(AuditReader); ok {
+			return reader.Recent(query)
+		}
+	}
+	c.auditMu.Lock()
+	defer c.auditMu.Unlock()
+	var result []ConfigChangeRecord
+	for i := len(c.auditBuffer) - 1; i >= 0 && len(result) < query.Limit; i-- {
+		if query.matches(c.auditBuffer[i]) {
+			result = append(result, c.auditBuffer[i])
+		}
+	}
+	return result, nil
+}
+
+// RecentChangesHandler serves RecentChanges with the key, since (RFC 3339) and limit query parameters
+func (c *ConsulClient) RecentChangesHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		query := AuditQuery{Key: ctx.Query("key")}
+		if since := ctx.Query("since"); since != "" {
+			parsed, err := time.Parse(time.RFC3339, since)
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "since must be an RFC 3339 timestamp"})
+				return
+			}
+			query.Since = parsed
+		}
+		query.Limit, _ = strconv.Atoi(ctx.Query("limit"))
+		changes, err := c.RecentChanges(query)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{"changes": changes})
+	}
+}
+
+// ----------------------------------------------------------------------------------------
+// File sink
+// ----------------------------------------------------------------------------------------
+
+// FileAuditSink appends changes as JSON lines to a file that is rotated when it exceeds maxSize
+type FileAuditSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	mu         sync.Mutex
+}
+
+// NewFileAuditSink writes to path, keeping maxBackups rotated files (path.1 is the most recent)
+func NewFileAuditSink(path string, maxSize int64, maxBackups int) (*FileAuditSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, err
+	}
+	if maxSize <= 0 {
+		maxSize = 10 << 20
+	}
+	return &FileAuditSink{path: path, maxSize: maxSize, maxBackups: max(maxBackups, 0)}, nil
+}
+
+func (s *FileAuditSink) Record(records []ConfigChangeRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if info, err := os.Stat(s.path); err == nil && info.Size() >= s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Recent reads the current file and its backups, newest first
+func (s *FileAuditSink) Recent(query AuditQuery) ([]ConfigChangeRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []ConfigChangeRecord
+	for i := 0; i <= s.maxBackups && len(result) < query.Limit; i++ {
+		path := s.path
+		if i > 0 {
+			path = s.path + "." + strconv.Itoa(i)
+		}
+		records, err := readAuditFile(path)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for j := len(records) - 1; j >= 0 && len(result) < query.Limit; j-- {
+			if query.matches(records[j]) {
+				result = append(result, records[j])
+			}
+		}
+	}
+	return result, nil
+}
+
+func (s *FileAuditSink) rotate() error {
+	if s.maxBackups == 0 {
+		return os.Remove(s.path)
+	}
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		from := s.path + "." + strconv.Itoa(i)
+		if _, err := os.Stat(from); err == nil {
+			if err := os.Rename(from, s.path+"."+strconv.Itoa(i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	return os.Rename(s.path, s.path+".1")
+}
+
+func readAuditFile(path string) ([]ConfigChangeRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var records []ConfigChangeRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	for scanner.Scan() {
+		var record ConfigChangeRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err == nil {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// ----------------------------------------------------------------------------------------
+// Gorm sink
+// ----------------------------------------------------------------------------------------
+
+// GormAuditSink stores changes in a database table
+type GormAuditSink struct {
+	db        *gorm.DB
+	tableName string
+}
+
+// NewGormAuditSink writes into tableName (defaults to "config_change_records")
+func NewGormAuditSink(db *gorm.DB, tableName string) *GormAuditSink {
+	if tableName == "" {
+		tableName = "config_change_records"
+	}
+	return &GormAuditSink{db: db, tableName: tableName}
+}
+
+// AutoMigrate creates or updates the audit table
+func (s *GormAuditSink) AutoMigrate() error {
+	return s.db.Table(s.tableName).AutoMigrate(&ConfigChangeRecord{})
+}
+
+func (s *GormAuditSink) Record(records []ConfigChangeRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return s.db.WithContext(ctx).Table(s.tableName).Create(&records).Error
+}
+
+func (s *GormAuditSink) Recent(query AuditQuery) ([]ConfigChangeRecord, error) {
+	tx := s.db.Table(s.tableName)
+	switch {
+	case strings.HasSuffix(query.Key, "/"):
+		like := `setting_key LIKE ? ESCAPE '\'`
+		if s.db.Dialector.Name() == "mysql" {
+			like = `setting_key LIKE ? ESCAPE '\\'` // Backslashes are escapes in MySQL string literals
+		}
+		tx = tx.Where(like, strings.NewReplacer("%", "\\%", "_", "\\_").Replace(query.Key)+"%")
+	case query.Key != "":
+		tx = tx.Where("setting_key = ?", query.Key)
+	}
+	if !query.Since.IsZero() {
+		tx = tx.Where("changed_at >= ?", query.Since)
+	}
+	var result []ConfigChangeRecord
+	err := tx.Order("changed_at desc, id desc").Limit(query.Limit).Find(&result).Error
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+
+// auditChanges records the accepted changes and the changes rejected by the schema. The records are kept
+// in memory at once and handed to the sinks by the audit worker, so a slow sink never delays the watcher.
+func (c *ConsulClient) auditChanges(accepted ChangeSet, rejected ChangeSet) {
+	if len(accepted.Changes)+len(rejected.Changes) == 0 {
+		return
+	}
+	instance, _ := os.Hostname()
+	now := time.Now()
+	records := make([]ConfigChangeRecord, 0, len(accepted.Changes)+len(rejected.Changes))
+	for _, change := range accepted.Changes {
+		records = append(records, c.auditRecord(change, false, instance, now))
+	}
+	for _, change := range rejected.Changes {
+		records = append(records, c.auditRecord(change, true, instance, now))
+	}
+
+	c.auditMu.Lock()
+	c.auditBuffer = append(c.auditBuffer, records...)
+	if overflow := len(c.auditBuffer) - auditBufferSize; overflow > 0 {
+		c.auditBuffer = append([]ConfigChangeRecord(nil), c.auditBuffer[overflow:]...)
+	}
+	if c.auditWake != nil {
+		c.auditPending = append(c.auditPending, records...)
+	}
+	c.auditMu.Unlock()
+
+	if c.auditWake != nil {
+		select {
+		case c.auditWake <- struct{}{}:
+		default: // The worker is already due to run
+		}
+	}
+}
+
+func (c *ConsulClient) auditRecord(change KeyChange, rejected bool, instance string, changedAt time.Time) ConfigChangeRecord {
+	record := ConfigChangeRecord{
+		Key:          change.Key,
+		Type:         change.Type,
+		OldValue:     change.OldValue,
+		OldValueHash: c.hashValue(change.OldValue),
+		NewValue:     change.NewValue,
+		NewValueHash: c.hashValue(change.NewValue),
+		Rejected:     rejected,
+		ModifyIndex:  change.ModifyIndex,
+		BasePath:     c.basePath,
+		Instance:     instance,
+		ChangedAt:    changedAt,
+	}
+	if c.isSecret(change.Key, change.OldValue) || c.isSecret(change.Key, change.NewValue) {
+		record.OldValue, record.NewValue, record.Redacted = "", "", true
+		if change.Type != ChangeAdded {
+			record.OldValue = redactedValue
+		}
+		if change.Type != ChangeDeleted {
+			record.NewValue = redactedValue
+		}
+		if c.auditHashKey == nil {
+			// An unkeyed hash of a secret can be brute-forced offline
+			record.OldValueHash, record.NewValueHash = "", ""
+		}
+	}
+	return record
+}
+
+// startAuditWorker starts the goroutine handing the audit records to the sinks, in order, until Close
+func (c *ConsulClient) startAuditWorker() {
+	if len(c.auditSinks) == 0 {
+		return
+	}
+	c.auditWake = make(chan struct{}, 1)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-c.auditWake:
+				c.flushAudit()
+			case <-stop:
+				c.flushAudit()
+				return
+			}
+		}
+	}()
+	c.onClose(func() {
+		close(stop)
+		<-done
+	})
+}
+
+// flushAudit hands the pending records to every sink
+func (c *ConsulClient) flushAudit() {
+	c.auditMu.Lock()
+	records := c.auditPending
+	c.auditPending = nil
+	c.auditMu.Unlock()
+	if len(records) == 0 {
+		return
+	}
+	for _, sink := range c.auditSinks {
+		if err := sink.Record(records); err != nil {
+			c.logger.Printf("Warning: Failed to record %d configuration changes in audit sink %T: %v", len(records), sink, err)
+		}
+	}
+}
+
+func (q AuditQuery) matches(record ConfigChangeRecord) bool {
+	if !q.Since.IsZero() && record.ChangedAt.Before(q.Since) {
+		return false
+	}
+	return changeSubscription{keyOrPrefix: q.Key}.matches(record.Key)
+}
+
+// hashValue returns the HMAC-SHA256 of the value with the audit hash key, or its SHA-256 without a key
+func (c *ConsulClient) hashValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	if c.auditHashKey != nil {
+		mac := hmac.New(sha256.New, c.auditHashKey)
+		mac.Write([]byte(value))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,102 @@
+package fxconsul_test
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tacjlee/common-sdk/packages/fxconsul"
+	"github.com/tacjlee/common-sdk/packages/fxconsul/consultest"
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestRejectedUpdatesAreAuditedOnce(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.SetAll(map[string]string{
+		"config/dev/settings/http/port": "8080",
+		"config/dev/settings/api/token": "tk-1",
+	})
+	var mu sync.Mutex
+	var records []fxconsul.ConfigChangeRecord
+	sink := fxconsul.AuditSinkFunc(func(batch []fxconsul.ConfigChangeRecord) error {
+		mu.Lock()
+		defer mu.Unlock()
+		records = append(records, batch...)
+		return nil
+	})
+	client := newClient(t, server, fxconsul.WithSchema(testSchema(t)), fxconsul.WithAuditSink(sink))
+	changes := subscribe(client, "")
+	watch(t, server, client)
+
+	server.Set("config/dev/settings/http/port", "http")
+	server.Set("config/dev/settings/log/level", "debug")
+	receive(t, changes)
+	// Every later listing still holds the rejected value
+	server.Set("config/dev/settings/log/level", "info")
+	receive(t, changes)
+	server.Set("config/dev/settings/api/token", "tk-2")
+	receive(t, changes)
+	client.Close() // Flushes the audit sinks
+
+	mu.Lock()
+	defer mu.Unlock()
+	rejected := 0
+	for _, record := range records {
+		switch {
+		case record.Rejected:
+			rejected++
+		case record.Key == "api/token":
+			if !record.Redacted || record.NewValue == "tk-2" || record.NewValueHash != "" {
+				t.Errorf("secret record %+v is not redacted or carries an unkeyed hash", record)
+			}
+		}
+	}
+	if rejected != 1 {
+		t.Errorf("%d rejected records, want 1: %+v", rejected, records)
+	}
+	if len(records) != 4 {
+		t.Errorf("%d records, want 4: %+v", len(records), records)
+	}
+}
+
+func TestFailedConstructionStartsNoAuditWorker(t *testing.T) {
+	sink := fxconsul.AuditSinkFunc(func([]fxconsul.ConfigChangeRecord) error { return nil })
+	options := []fxconsul.Option{
+		fxconsul.WithEnabled(false),
+		fxconsul.WithLogger(discardLogger),
+		fxconsul.WithSchema(testSchema(t)),
+		fxconsul.WithStrictSettings(true),
+		fxconsul.WithAuditSink(sink),
+	}
+	before := runtime.NumGoroutine()
+	for range 20 {
+		if _, err := fxconsul.NewConsulClient(options...); err == nil {
+			t.Fatal("NewConsulClient succeeded without the required http/port")
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	if leaked := runtime.NumGoroutine() - before; leaked >= 20 {
+		t.Errorf("%d goroutines leaked by failed constructions", leaked)
+	}
+}
+
+func TestGormAuditSinkEscapesThePrefix(t *testing.T) {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	if err != nil {
+		t.Fatalf("gorm.Open: %v", err)
+	}
+	var statement string
+	_ = db.Callback().Query().After("gorm:query").Register("capture", func(tx *gorm.DB) {
+		statement = tx.Statement.SQL.String()
+	})
+	sink := fxconsul.NewGormAuditSink(db, "")
+	if _, err := sink.Recent(fxconsul.AuditQuery{Key: "feature_flags/", Limit: 10}); err != nil {
+		t.Fatalf("Recent: %v", err)
+	}
+	if !strings.Contains(statement, `setting_key LIKE ? ESCAPE '\'`) {
+		t.Errorf("Recent ran %q, want an escaped LIKE", statement)
+	}
+}
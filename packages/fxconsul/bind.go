@@ -0,0 +1,365 @@
+package fxconsul
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+var (
+	bindValidator     *validator.Validate
+	bindValidatorOnce sync.Once
+)
+
+// Bind populates the struct pointed to by target from Consul settings.
+// Fields are mapped with tags relative to basePath:
+//
+//	type DBConfig struct {
+//		MaxConns int           `consul:"db/maxConns" default:"10" validate:"min=1"`
+//		Timeout  time.Duration `consul:"db/timeout" default:"5s"`
+//		Expires  time.Time     `consul:"db/expires"`        // RFC 3339
+//		Hosts    []string      `consul:"db/hosts"`          // JSON array or comma separated
+//		Labels   map[string]string `consul:"db/labels"`     // JSON object or k=v pairs
+//		Cache    CacheConfig   `consul:"cache"`             // Nested struct, keys prefixed with "cache/"
+//		Password string        `consul:"db/password"`       // "enc:v1:" values are decrypted with the keyring
+//	}
+//
+// A schema default (WithSchema) takes precedence over the `default` tag.
+// The struct is validated with the `validate` tags once every field is set.
+func (c *ConsulClient) Bind(ctx context.Context, target any) error {
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("bind target must be a pointer to a struct, got %T", target)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var errs []error
+	c.bindStruct(ctx, value.Elem(), "", &errs)
+	// A read cut short by the context falls back to the default, which must not be mistaken for the setting
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return validateStruct(target)
+}
+
+// Watched holds a configuration struct bound from Consul that is atomically replaced
+// whenever a key under its prefix changes. Invalid updates are rejected and the previous value is kept.
+// The client must be watching (WatchConfig) for updates to be delivered.
+type Watched[T any] struct {
+	client      *ConsulClient
+	value       atomic.Pointer[T]
+	reloadMu    sync.Mutex
+	listeners   []func(old *T, new *T)
+	onError     func(err error)
+	unsubscribe func()
+}
+
+// NewWatched binds T once and reloads it on every change of a key under prefix ("" for all keys).
+// "db" and "db/" both watch the keys under "db/". Close stops the updates.
+func NewWatched[T any](ctx context.Context, client *ConsulClient, prefix string) (*Watched[T], error) {
+	w := &Watched[T]{client: client}
+	initial := new(T)
+	if err := client.Bind(ctx, initial); err != nil {
+		return nil, err
+	}
+	w.value.Store(initial)
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	w.unsubscribe = client.OnKeyChange(prefix, func(changes ChangeSet) {
+		w.Reload(context.Background())
+	})
+	return w, nil
+}
+
+// Close stops reloading the configuration; Get keeps returning the last value
+func (w *Watched[T]) Close() {
+	w.unsubscribe()
+}
+
+// Get returns the current configuration. The returned value must be treated as read-only.
+func (w *Watched[T]) Get() *T {
+	return w.value.Load()
+}
+
+// OnUpdate registers a listener called after a new configuration has been swapped in
+func (w *Watched[T]) OnUpdate(listener func(old *T, new *T)) {
+	w.reloadMu.Lock()
+	defer w.reloadMu.Unlock()
+	w.listeners = append(w.listeners, listener)
+}
+
+// OnError registers a handler for rejected updates
+func (w *Watched[T]) OnError(handler func(err error)) {
+	w.reloadMu.Lock()
+	defer w.reloadMu.Unlock()
+	w.onError = handler
+}
+
+// Reload binds and validates a fresh copy, swapping it in only when it is valid
+func (w *Watched[T]) Reload(ctx context.Context) error {
+	w.reloadMu.Lock()
+	defer w.reloadMu.Unlock()
+
+	next := new(T)
+	if err := w.client.Bind(ctx, next); err != nil {
+		w.client.logger.Printf("Warning: Rejected configuration update for %T: %v", next, err)
+		if w.onError != nil {
+			w.onError(err)
+		}
+		return err
+	}
+	old := w.value.Swap(next)
+	for _, listener := range w.listeners {
+		listener(old, next)
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+func (c *ConsulClient) bindStruct(ctx context.Context, target reflect.Value, prefix string, errs *[]error) {
+	targetType := target.Type()
+	for i := 0; i < targetType.NumField(); i++ {
+		field := targetType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("consul")
+		if tag == "-" {
+			continue
+		}
+		fieldValue := target.Field(i)
+		if field.Type.Kind() == reflect.Struct && field.Type != timeType {
+			nestedPrefix := prefix
+			if tag != "" {
+				nestedPrefix = prefix + strings.TrimSuffix(tag, "/") + "/"
+			}
+			c.bindStruct(ctx, fieldValue, nestedPrefix, errs)
+			continue
+		}
+		if tag == "" {
+			continue
+		}
+		key := prefix + tag
+		raw := c.GetSettingContext(ctx, key, "")
+		if raw == "" {
+			raw = field.Tag.Get("default")
+		}
+		if raw == "" {
+			continue
+		}
+		raw, err := c.decryptValue(raw)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("%s (%s): %w", key, field.Name, err))
+			continue
+		}
+		if err := setFieldValue(fieldValue, raw); err != nil {
+			*errs = append(*errs, fmt.Errorf("%s (%s): %w", key, field.Name, err))
+		}
+	}
+}
+
+// setFieldValue converts the raw setting into the field type
+func setFieldValue(field reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch field.Kind() {
+	case reflect.Slice:
+		items, err := splitList(raw)
+		if err != nil {
+			return err
+		}
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setFieldValue(slice.Index(i), item); err != nil {
+				return fmt.Errorf("item %d: %w", i, err)
+			}
+		}
+		field.Set(slice)
+		return nil
+	case reflect.Map:
+		entries, err := splitMap(raw)
+		if err != nil {
+			return err
+		}
+		result := reflect.MakeMapWithSize(field.Type(), len(entries))
+		for k, v := range entries {
+			key := reflect.New(field.Type().Key()).Elem()
+			if err := setFieldValue(key, k); err != nil {
+				return fmt.Errorf("key %q: %w", k, err)
+			}
+			item := reflect.New(field.Type().Elem()).Elem()
+			if err := setFieldValue(item, v); err != nil {
+				return fmt.Errorf("value of %q: %w", k, err)
+			}
+			result.SetMapIndex(key, item)
+		}
+		field.Set(result)
+		return nil
+	case reflect.Ptr:
+		item := reflect.New(field.Type().Elem())
+		if err := setFieldValue(item.Elem(), raw); err != nil {
+			return err
+		}
+		field.Set(item)
+		return nil
+	}
+	return setScalar(field, raw)
+}
+
+func setScalar(field reflect.Value, raw string) error {
+	if field.Type() == durationType {
+		duration, err := parseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+		return nil
+	}
+	if field.Type() == timeType {
+		value, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(value))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		value, err := parseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value, err := strconv.ParseUint(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(value)
+	case reflect.Float32, reflect.Float64:
+		value, err := strconv.ParseFloat(raw, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(value)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+func parseBool(raw string) (bool, error) {
+	switch strings.ToLower(raw) {
+	case "true", "1", "yes":
+		return true, nil
+	case "false", "0", "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean %q", raw)
+}
+
+// parseDuration accepts a duration such as "1m30s" or a plain integer number of seconds
+func parseDuration(raw string) (time.Duration, error) {
+	if seconds, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(raw)
+}
+
+// splitList accepts a JSON array or a comma separated list
+func splitList(raw string) ([]string, error) {
+	if strings.HasPrefix(raw, "[") {
+		var items []any
+		if err := json.Unmarshal([]byte(raw), &items); err != nil {
+			return nil, err
+		}
+		result := make([]string, len(items))
+		for i, item := range items {
+			result[i] = jsonScalarString(item)
+		}
+		return result, nil
+	}
+	parts := strings.Split(raw, ",")
+	result := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			result = append(result, part)
+		}
+	}
+	return result, nil
+}
+
+// splitMap accepts a JSON object or comma separated key=value pairs
+func splitMap(raw string) (map[string]string, error) {
+	result := make(map[string]string)
+	if strings.HasPrefix(raw, "{") {
+		var entries map[string]any
+		if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+			return nil, err
+		}
+		for k, v := range entries {
+			result[k] = jsonScalarString(v)
+		}
+		return result, nil
+	}
+	for _, pair := range strings.Split(raw, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		k, v, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid map entry %q, expected key=value", pair)
+		}
+		result[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return result, nil
+}
+
+func jsonScalarString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+func validateStruct(target any) error {
+	bindValidatorOnce.Do(func() {
+		bindValidator = validator.New()
+	})
+	return bindValidator.Struct(target)
+}
@@ -0,0 +1,96 @@
+package fxconsul_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tacjlee/common-sdk/packages/fxconsul"
+	"github.com/tacjlee/common-sdk/packages/fxconsul/consultest"
+)
+
+type poolConfig struct {
+	MaxConns int    `consul:"maxConns" default:"10" validate:"min=1"`
+	Mode     string `consul:"mode" default:"fifo"`
+}
+
+func TestWatchedRejectsInvalidUpdates(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.Set("config/dev/settings/maxConns", "20")
+	client := newClient(t, server)
+	watched, err := fxconsul.NewWatched[poolConfig](context.Background(), client, "")
+	if err != nil {
+		t.Fatalf("NewWatched: %v", err)
+	}
+	defer watched.Close()
+	if got := *watched.Get(); got != (poolConfig{MaxConns: 20, Mode: "fifo"}) {
+		t.Fatalf("initial config = %+v", got)
+	}
+	updates := make(chan poolConfig, 4)
+	errs := make(chan error, 4)
+	watched.OnUpdate(func(old *poolConfig, new *poolConfig) { updates <- *new })
+	watched.OnError(func(err error) { errs <- err })
+	watch(t, server, client)
+
+	server.Set("config/dev/settings/maxConns", "0")
+	select {
+	case <-errs:
+	case update := <-updates:
+		t.Fatalf("invalid update applied: %+v", update)
+	case <-time.After(waitTimeout):
+		t.Fatal("invalid update not reported")
+	}
+	if got := watched.Get().MaxConns; got != 20 {
+		t.Errorf("MaxConns after an invalid update = %d, want 20", got)
+	}
+
+	server.Set("config/dev/settings/maxConns", "30")
+	select {
+	case update := <-updates:
+		if update.MaxConns != 30 {
+			t.Errorf("MaxConns after a valid update = %d, want 30", update.MaxConns)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("valid update not applied")
+	}
+}
+
+type timeoutConfig struct {
+	Timeout time.Duration `consul:"http/timeout"`
+	Retry   time.Duration `consul:"http/retry" default:"2"`
+}
+
+func TestBindReadsDurationsLikeGetSettingDuration(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.Set("config/dev/settings/http/timeout", "30")
+	client := newClient(t, server)
+
+	var config timeoutConfig
+	if err := client.Bind(context.Background(), &config); err != nil {
+		t.Fatalf("Bind: %v", err)
+	}
+	if config.Timeout != 30*time.Second || config.Retry != 2*time.Second {
+		t.Errorf("bound %+v, want plain integers read as seconds", config)
+	}
+	if got := client.GetSettingDuration("http/timeout", 0); got != config.Timeout {
+		t.Errorf("GetSettingDuration = %s, Bind = %s", got, config.Timeout)
+	}
+}
+
+func TestBindStopsWhenContextEnds(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.Set("config/dev/settings/http/timeout", "5s")
+	client := newClient(t, server)
+	server.SetLatency(time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	var config timeoutConfig
+	if err := client.Bind(ctx, &config); err != context.DeadlineExceeded {
+		t.Errorf("Bind = %v, want the context deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Bind returned after %s, want about 50ms", elapsed)
+	}
+}
@@ -0,0 +1,192 @@
+package fxconsul
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// ChangeType describes how a key changed between two watch snapshots
+type ChangeType string
+
+const (
+	ChangeAdded    ChangeType = "added"
+	ChangeModified ChangeType = "modified"
+	ChangeDeleted  ChangeType = "deleted"
+)
+
+// KeyChange is a single key change with its old and new values (relative to basePath)
+type KeyChange struct {
+	Key         string
+	Type        ChangeType
+	OldValue    string
+	NewValue    string
+	ModifyIndex uint64
+}
+
+// ChangeSet is the set of changes detected at a Consul index
+type ChangeSet struct {
+	Index   uint64
+	Changes []KeyChange
+}
+
+// ChangeSetCallback is called with the detected changes
+type ChangeSetCallback func(changes ChangeSet)
+
+// Keys returns the changed keys in sorted order
+func (cs ChangeSet) Keys() []string {
+	keys := make([]string, 0, len(cs.Changes))
+	for _, change := range cs.Changes {
+		keys = append(keys, change.Key)
+	}
+	return keys
+}
+
+// Get returns the change of the given key, if any
+func (cs ChangeSet) Get(key string) (KeyChange, bool) {
+	for _, change := range cs.Changes {
+		if change.Key == key {
+			return change, true
+		}
+	}
+	return KeyChange{}, false
+}
+
+// OnChange registers a callback receiving every change set with old and new values.
+// The returned function unregisters it.
+func (c *ConsulClient) OnChange(callback ChangeSetCallback) func() {
+	return c.OnKeyChange("", callback)
+}
+
+// OnKeyChange registers a callback for a single key, or for every key under a prefix when
+// keyOrPrefix ends with "/" (e.g. OnKeyChange("db/", fn)). The callback only receives matching changes.
+// The returned function unregisters it.
+func (c *ConsulClient) OnKeyChange(keyOrPrefix string, callback ChangeSetCallback) func() {
+	c.callbackMu.Lock()
+	defer c.callbackMu.Unlock()
+	c.subscriptionSeq++
+	id := c.subscriptionSeq
+	c.subscriptions = append(c.subscriptions, changeSubscription{id: id, keyOrPrefix: keyOrPrefix, callback: callback, queue: &deliveryQueue{}})
+	return func() {
+		c.callbackMu.Lock()
+		defer c.callbackMu.Unlock()
+		c.subscriptions = slices.DeleteFunc(c.subscriptions, func(s changeSubscription) bool {
+			return s.id == id
+		})
+	}
+}
+
+type changeSubscription struct {
+	id          uint64
+	keyOrPrefix string
+	callback    ChangeSetCallback
+	queue       *deliveryQueue
+}
+
+func (s changeSubscription) matches(key string) bool {
+	if s.keyOrPrefix == "" {
+		return true
+	}
+	if strings.HasSuffix(s.keyOrPrefix, "/") {
+		return strings.HasPrefix(key, s.keyOrPrefix)
+	}
+	return key == s.keyOrPrefix
+}
+
+// deliveryQueue runs the deliveries of one subscription in order, on a goroutine that only lives while
+// deliveries are pending, so a slow subscriber neither blocks the watch nor sees change sets out of order
+type deliveryQueue struct {
+	mu      sync.Mutex
+	pending []func()
+	running bool
+}
+
+func (q *deliveryQueue) push(deliver func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, deliver)
+	if !q.running {
+		q.running = true
+		go q.drain()
+	}
+}
+
+func (q *deliveryQueue) drain() {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		deliver := q.pending[0]
+		q.pending[0] = nil
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+		deliver()
+	}
+}
+
+type kvEntry struct {
+	value       string
+	modifyIndex uint64
+}
+
+func (cs ChangeSet) filter(match func(key string) bool) ChangeSet {
+	filtered := ChangeSet{Index: cs.Index}
+	for _, change := range cs.Changes {
+		if match(change.Key) {
+			filtered.Changes = append(filtered.Changes, change)
+		}
+	}
+	return filtered
+}
+
+// snapshotFromPairs merges the KV pairs of every layer by their relative key, more specific layers
+// overriding less specific ones, and skipping folder entries
+func (c *ConsulClient) snapshotFromPairs(pairs api.KVPairs) map[string]kvEntry {
+	snapshot := make(map[string]kvEntry, len(pairs))
+	for i := len(c.layers) - 1; i >= 0; i-- {
+		for _, pair := range pairs {
+			if key, ok := c.layerKey(i, pair.Key); ok {
+				snapshot[key] = kvEntry{value: string(pair.Value), modifyIndex: pair.ModifyIndex}
+			}
+		}
+	}
+	return snapshot
+}
+
+func diffSnapshot(previous map[string]kvEntry, current map[string]kvEntry, index uint64) ChangeSet {
+	changes := ChangeSet{Index: index}
+	for key, entry := range current {
+		old, existed := previous[key]
+		switch {
+		case !existed:
+			changes.Changes = append(changes.Changes, KeyChange{Key: key, Type: ChangeAdded, NewValue: entry.value, ModifyIndex: entry.modifyIndex})
+		case old.value != entry.value:
+			changes.Changes = append(changes.Changes, KeyChange{Key: key, Type: ChangeModified, OldValue: old.value, NewValue: entry.value, ModifyIndex: entry.modifyIndex})
+		}
+	}
+	for key, old := range previous {
+		if _, exists := current[key]; !exists {
+			changes.Changes = append(changes.Changes, KeyChange{Key: key, Type: ChangeDeleted, OldValue: old.value, ModifyIndex: index})
+		}
+	}
+	sort.Slice(changes.Changes, func(i, j int) bool {
+		return changes.Changes[i].Key < changes.Changes[j].Key
+	})
+	return changes
+}
+
+// invalidateKeys removes the given keys from the cache so the next read fetches the new value
+func (c *ConsulClient) invalidateKeys(keys []string) {
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+	for _, key := range keys {
+		delete(c.cache, key)
+	}
+	c.cacheGen++
+}
@@ -0,0 +1,120 @@
+package fxconsul_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tacjlee/common-sdk/packages/fxconsul"
+	"github.com/tacjlee/common-sdk/packages/fxconsul/consultest"
+)
+
+func TestChangeSetHoldsOldAndNewValues(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.SetAll(map[string]string{
+		"config/dev/settings/db/host": "db1",
+		"config/dev/settings/db/port": "5432",
+	})
+	client := newClient(t, server)
+	changes := subscribe(client, "")
+	watch(t, server, client)
+
+	// A single transaction is delivered as one change set
+	server.SetAll(map[string]string{
+		"config/dev/settings/db/host": "db2",
+		"config/dev/settings/db/user": "app",
+	})
+	cs := receive(t, changes)
+	if got, want := cs.Keys(), []string{"db/host", "db/user"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("changed keys = %v, want %v", got, want)
+	}
+	host, _ := cs.Get("db/host")
+	if host.Type != fxconsul.ChangeModified || host.OldValue != "db1" || host.NewValue != "db2" {
+		t.Errorf("db/host change = %+v, want modified db1 -> db2", host)
+	}
+	user, _ := cs.Get("db/user")
+	if user.Type != fxconsul.ChangeAdded || user.NewValue != "app" {
+		t.Errorf("db/user change = %+v, want added app", user)
+	}
+	if cs.Index != server.Index() {
+		t.Errorf("change set index = %d, want %d", cs.Index, server.Index())
+	}
+
+	server.Delete("config/dev/settings/db/port")
+	cs = receive(t, changes)
+	port, ok := cs.Get("db/port")
+	if !ok || port.Type != fxconsul.ChangeDeleted || port.OldValue != "5432" {
+		t.Errorf("change set %+v does not delete db/port", cs)
+	}
+}
+
+func TestOnKeyChangeFiltersAndUnsubscribes(t *testing.T) {
+	server := consultest.NewServer(t)
+	client := newClient(t, server)
+	dbChanges := subscribe(client, "db/")
+	hostChanges := make(chan fxconsul.ChangeSet, 16)
+	unsubscribe := client.OnKeyChange("db/host", func(cs fxconsul.ChangeSet) {
+		hostChanges <- cs
+	})
+	watch(t, server, client)
+
+	server.SetAll(map[string]string{
+		"config/dev/settings/db/host":   "db1",
+		"config/dev/settings/cache/ttl": "1m",
+	})
+	if got := receive(t, dbChanges).Keys(); !reflect.DeepEqual(got, []string{"db/host"}) {
+		t.Errorf("db/ subscription received %v, want [db/host]", got)
+	}
+	if got := receive(t, hostChanges).Keys(); !reflect.DeepEqual(got, []string{"db/host"}) {
+		t.Errorf("db/host subscription received %v, want [db/host]", got)
+	}
+
+	unsubscribe()
+	server.Set("config/dev/settings/db/host", "db2")
+	receive(t, dbChanges)
+	expectNone(t, hostChanges)
+
+	server.Set("config/dev/settings/cache/ttl", "2m")
+	expectNone(t, dbChanges)
+}
+
+func TestSlowSubscriberReceivesChangeSetsInOrder(t *testing.T) {
+	server := consultest.NewServer(t)
+	client := newClient(t, server)
+	entered := make(chan struct{})
+	values := make(chan string, 16)
+	first := true
+	client.OnKeyChange("feature", func(cs fxconsul.ChangeSet) {
+		if first {
+			first = false
+			close(entered)
+			time.Sleep(200 * time.Millisecond)
+		}
+		change, _ := cs.Get("feature")
+		values <- change.NewValue
+	})
+	changes := subscribe(client, "feature")
+	watch(t, server, client)
+
+	server.Set("config/dev/settings/feature", "v1")
+	receive(t, changes)
+	<-entered
+	// The next change sets are detected while the first one is still being handled
+	server.Set("config/dev/settings/feature", "v2")
+	receive(t, changes)
+	server.Set("config/dev/settings/feature", "v3")
+	receive(t, changes)
+
+	var got []string
+	for len(got) < 3 {
+		select {
+		case value := <-values:
+			got = append(got, value)
+		case <-time.After(waitTimeout):
+			t.Fatalf("received %v, want [v1 v2 v3]", got)
+		}
+	}
+	if !reflect.DeepEqual(got, []string{"v1", "v2", "v3"}) {
+		t.Errorf("received %v, want [v1 v2 v3]", got)
+	}
+}
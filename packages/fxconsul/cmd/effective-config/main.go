@@ -0,0 +1,105 @@
+// Command effective-config prints the merged configuration of a service environment, resolved through
+// config/{service}/{env} → config/{service}/default → config/shared/{env} → config/shared.
+//
+//	CONSUL_HOST=consul.internal effective-config -service billing -env prod
+//	effective-config -service billing -env prod -format yaml -sources
+//	effective-config -service billing -env prod -show-secrets
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/tacjlee/common-sdk/packages/fxconsul"
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "******"
+
+func main() {
+	service := flag.String("service", os.Getenv("CONSUL_SERVICE"), "service name")
+	env := flag.String("env", os.Getenv("CONSUL_ENV"), "environment name")
+	address := flag.String("address", envOrDefault("CONSUL_HOST", "localhost")+":"+envOrDefault("CONSUL_PORT", "8500"), "Consul agent address")
+	format := flag.String("format", "json", "output format: json, yaml or env")
+	sources := flag.Bool("sources", false, "include the layer each value was resolved from")
+	showSecrets := flag.Bool("show-secrets", false, "print values of secret-looking keys instead of redacting them")
+	flag.Parse()
+
+	if *service == "" || *env == "" {
+		log.Fatal("-service and -env are required")
+	}
+
+	client, err := fxconsul.NewConsulClient(
+		fxconsul.WithAddress(*address),
+		fxconsul.WithToken(os.Getenv("CONSUL_HTTP_TOKEN")),
+		fxconsul.WithProfile(*service, *env),
+		fxconsul.WithLogger(log.New(os.Stderr, "", 0)),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+
+	settings, err := client.EffectiveSettings()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !*showSecrets {
+		for key, setting := range settings {
+			if fxconsul.DefaultSecretPattern.MatchString(key) || fxconsul.IsEncrypted(setting.Value) {
+				setting.Value = redacted
+				settings[key] = setting
+			}
+		}
+	}
+
+	if err := write(settings, *format, *sources); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func write(settings map[string]fxconsul.EffectiveSetting, format string, sources bool) error {
+	if format == "env" {
+		keys := make([]string, 0, len(settings))
+		for key := range settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if sources {
+				fmt.Printf("# %s\n", settings[key].Layer)
+			}
+			fmt.Printf("%s=%q\n", key, settings[key].Value)
+		}
+		return nil
+	}
+
+	var document any = settings
+	if !sources {
+		values := make(map[string]string, len(settings))
+		for key, setting := range settings {
+			values[key] = setting.Value
+		}
+		document = values
+	}
+	switch format {
+	case "yaml":
+		return yaml.NewEncoder(os.Stdout).Encode(document)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(document)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+func envOrDefault(key string, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
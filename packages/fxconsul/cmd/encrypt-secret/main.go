@@ -0,0 +1,86 @@
+// Command encrypt-secret encrypts a value for storage in Consul KV as an "enc:v1:" envelope.
+//
+//	CONSUL_SECRET_KEYS="v2:<base64>,v1:<base64>" encrypt-secret 's3cr3t'
+//	echo -n 's3cr3t' | encrypt-secret -keys-file /etc/app/keyring
+//	encrypt-secret -generate-key                 # prints a new base64 AES-256 key
+//	encrypt-secret -rewrap 'enc:v1:v1:...'       # re-wraps with the primary key version
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/tacjlee/common-sdk/packages/fxconsul"
+)
+
+func main() {
+	keysFile := flag.String("keys-file", "", "keyring file with version:base64key lines (first is primary), defaults to CONSUL_SECRET_KEYS then CONSUL_SECRET_KEYS_FILE")
+	generateKey := flag.Bool("generate-key", false, "print a new random base64 AES-256 key and exit")
+	rewrap := flag.Bool("rewrap", false, "re-wrap an encrypted value with the primary key version")
+	decrypt := flag.Bool("decrypt", false, "decrypt an encrypted value")
+	flag.Parse()
+
+	if *generateKey {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("generate key: %v", err)
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(key))
+		return
+	}
+
+	keyring, err := loadKeyring(*keysFile)
+	if err != nil {
+		log.Fatalf("load keyring: %v", err)
+	}
+	value, err := readValue()
+	if err != nil {
+		log.Fatalf("read value: %v", err)
+	}
+
+	var result string
+	switch {
+	case *rewrap:
+		result, err = keyring.Rewrap(value)
+	case *decrypt:
+		result, err = keyring.Decrypt(value)
+	default:
+		result, err = keyring.Encrypt(value)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(result)
+}
+
+// loadKeyring reads the keyring from the -keys-file flag when given, otherwise from the environment
+func loadKeyring(keysFile string) (*fxconsul.SecretKeyring, error) {
+	if keysFile != "" {
+		return fxconsul.LoadSecretKeyringFile(keysFile)
+	}
+	if spec := os.Getenv("CONSUL_SECRET_KEYS"); spec != "" {
+		return fxconsul.ParseSecretKeyring(spec)
+	}
+	if keysFile = os.Getenv("CONSUL_SECRET_KEYS_FILE"); keysFile != "" {
+		return fxconsul.LoadSecretKeyringFile(keysFile)
+	}
+	return nil, fmt.Errorf("set -keys-file, CONSUL_SECRET_KEYS or CONSUL_SECRET_KEYS_FILE")
+}
+
+// readValue takes the value from the first argument, or from stdin when no argument is given
+func readValue() (string, error) {
+	if flag.NArg() > 0 {
+		return flag.Arg(0), nil
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,439 @@
+package fxconsul
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/tacjlee/common-sdk/packages/fxcache"
+)
+
+// Built-in commands
+const (
+	CommandClearCache    = "clear-cache"    // Clears fxcache
+	CommandRefreshConfig = "refresh-config" // Clears the ConsulClient settings cache
+	CommandSetLogLevel   = "set-log-level"  // Payload LogLevelPayload; needs CommandBusConfig.LogLevel
+)
+
+// maxEventPayload is the size limit Consul puts on user events
+const maxEventPayload = 512
+
+// seenCommandsSize is the number of command IDs remembered for de-duplication
+const seenCommandsSize = 1024
+
+// CommandTransport selects how commands reach the replicas
+type CommandTransport int
+
+const (
+	// CommandTransportEvents uses Consul user events: gossip based, best effort and limited to 512 bytes
+	CommandTransportEvents CommandTransport = iota
+	// CommandTransportKV writes commands under a KV prefix: reliable, any payload size, pruned after the TTL
+	CommandTransportKV
+)
+
+// Command is a remote command delivered to every listening replica
+type Command struct {
+	ID      string          `json:"id,omitempty"`
+	Name    string          `json:"name,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Origin  string          `json:"origin"` // Hostname of the sender
+	FiredAt time.Time       `json:"firedAt"`
+}
+
+// Decode unmarshals the JSON payload into target
+func (cmd Command) Decode(target any) error {
+	if len(cmd.Payload) == 0 {
+		return nil
+	}
+	return json.Unmarshal(cmd.Payload, target)
+}
+
+// CommandHandler executes a command
+type CommandHandler func(ctx context.Context, cmd Command) error
+
+// LogLevelPayload is the payload of CommandSetLogLevel. An empty level toggles between info and debug;
+// a positive duration restores the previous level afterwards (e.g. "10m").
+type LogLevelPayload struct {
+	Level    string `json:"level,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// CommandBusConfig configures a CommandBus
+type CommandBusConfig struct {
+	Transport CommandTransport
+	// Prefix of the event names (defaults to "fxcmd.") or the KV prefix (defaults to "fxconsul/commands/").
+	// The KV prefix must be outside the settings base path.
+	Prefix string
+	// TTL after which KV commands are ignored and pruned, defaults to 10 minutes
+	TTL time.Duration
+	// LogLevel enables the CommandSetLogLevel handler
+	LogLevel *slog.LevelVar
+}
+
+// CommandBus fires commands to every replica and runs the handlers registered for them.
+// Each command is executed at most once per bus, including on the replica that fired it.
+type CommandBus struct {
+	client   *ConsulClient
+	config   CommandBusConfig
+	origin   string
+	handlers map[string]CommandHandler
+	mu       sync.RWMutex
+
+	seen      map[string]struct{}
+	seenOrder []string
+	seenMu    sync.Mutex
+
+	levelTimer   *time.Timer
+	levelRestore slog.Level
+	levelGen     uint64 // Bumped on every change, so that a restore already waiting on levelMu is dropped
+	levelMu      sync.Mutex
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	unclose func()
+	runMu   sync.Mutex // Guards cancel, done and unclose across Start and Stop
+}
+
+// NewCommandBus creates a bus with the built-in handlers registered. Call Start to begin listening.
+func (c *ConsulClient) NewCommandBus(config CommandBusConfig) *CommandBus {
+	if config.Prefix == "" {
+		config.Prefix = "fxcmd."
+		if config.Transport == CommandTransportKV {
+			config.Prefix = "fxconsul/commands/"
+		}
+	}
+	if config.Transport == CommandTransportKV && !strings.HasSuffix(config.Prefix, "/") {
+		config.Prefix += "/"
+	}
+	if config.TTL <= 0 {
+		config.TTL = 10 * time.Minute
+	}
+	origin, _ := os.Hostname()
+	b := &CommandBus{
+		client:   c,
+		config:   config,
+		origin:   origin,
+		handlers: make(map[string]CommandHandler),
+		seen:     make(map[string]struct{}),
+	}
+	b.Handle(CommandClearCache, b.clearCache)
+	b.Handle(CommandRefreshConfig, b.refreshConfig)
+	if config.LogLevel != nil {
+		b.Handle(CommandSetLogLevel, b.setLogLevel)
+	}
+	return b
+}
+
+// Handle registers the handler of a command, replacing any previous one
+func (b *CommandBus) Handle(name string, handler CommandHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[name] = handler
+}
+
+// Fire sends a command to every replica. The payload is encoded as JSON (nil for none).
+// Returns the command ID.
+func (b *CommandBus) Fire(ctx context.Context, name string, payload any) (string, error) {
+	if b.client.client == nil {
+		return "", fmt.Errorf("consul client is not configured")
+	}
+	cmd := Command{Origin: b.origin, FiredAt: time.Now().UTC()}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return "", fmt.Errorf("encode payload of command %s: %w", name, err)
+		}
+		cmd.Payload = data
+	}
+
+	if b.config.Transport == CommandTransportKV {
+		return b.fireKV(ctx, name, cmd)
+	}
+	body, err := json.Marshal(cmd)
+	if err != nil {
+		return "", err
+	}
+	if len(body) > maxEventPayload {
+		return "", fmt.Errorf("command %s is %d bytes, user events are limited to %d bytes: use CommandTransportKV", name, len(body), maxEventPayload)
+	}
+	id, _, err := b.client.client.Event().Fire(&api.UserEvent{Name: b.config.Prefix + name, Payload: body}, (&api.WriteOptions{}).WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("fire command %s: %w", name, err)
+	}
+	return id, nil
+}
+
+// Start begins listening until ctx is cancelled, Stop is called or the client is closed.
+// Commands fired before Start are not executed.
+func (b *CommandBus) Start(ctx context.Context) error {
+	if b.client.client == nil {
+		return fmt.Errorf("consul client is not configured")
+	}
+	b.runMu.Lock()
+	defer b.runMu.Unlock()
+	if b.done != nil {
+		return fmt.Errorf("command bus already started")
+	}
+	commands, index, err := b.poll(ctx, 0)
+	if err != nil {
+		return err
+	}
+	for _, cmd := range commands {
+		b.markSeen(cmd.ID)
+	}
+	ctx, b.cancel = context.WithCancel(ctx)
+	b.done = make(chan struct{})
+	go b.listenLoop(ctx, index)
+	b.unclose = b.client.onClose(b.Stop)
+	return nil
+}
+
+// Stop ends listening and waits for the running handler, if any, to return.
+// A temporary log level change is reverted rather than left in place for good.
+func (b *CommandBus) Stop() {
+	b.runMu.Lock()
+	defer b.runMu.Unlock()
+	if b.cancel == nil {
+		return
+	}
+	b.cancel()
+	<-b.done
+	b.unclose()
+	b.restoreLogLevel()
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+func (b *CommandBus) listenLoop(ctx context.Context, index uint64) {
+	defer close(b.done)
+	for {
+		commands, lastIndex, err := b.poll(ctx, index)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			b.client.logger.Printf("Warning: Error listening for commands: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(b.client.retryDelay()):
+				continue
+			}
+		}
+		for _, cmd := range commands {
+			if b.markSeen(cmd.ID) {
+				b.execute(ctx, cmd)
+			}
+		}
+		if lastIndex < index && b.config.Transport == CommandTransportKV {
+			lastIndex = 0 // Index went backwards, start over; the event index is a hash and is not ordered
+		}
+		index = lastIndex
+	}
+}
+
+// poll returns the commands currently known to Consul, oldest first
+func (b *CommandBus) poll(ctx context.Context, waitIndex uint64) ([]Command, uint64, error) {
+	opts := (&api.QueryOptions{WaitIndex: waitIndex, WaitTime: 30 * time.Second}).WithContext(ctx)
+	if b.config.Transport == CommandTransportKV {
+		pairs, meta, err := b.client.client.KV().List(b.config.Prefix, opts)
+		if err != nil {
+			return nil, 0, err
+		}
+		sort.Slice(pairs, func(i, j int) bool {
+			return pairs[i].ModifyIndex < pairs[j].ModifyIndex
+		})
+		commands := make([]Command, 0, len(pairs))
+		for _, pair := range pairs {
+			var cmd Command
+			if err := json.Unmarshal(pair.Value, &cmd); err != nil {
+				b.client.logger.Printf("Warning: Ignoring invalid command %s: %v", pair.Key, err)
+				continue
+			}
+			if time.Since(cmd.FiredAt) > b.config.TTL {
+				continue
+			}
+			cmd.ID = strings.TrimPrefix(pair.Key, b.config.Prefix)
+			commands = append(commands, cmd)
+		}
+		return commands, meta.LastIndex, nil
+	}
+
+	events, meta, err := b.client.client.Event().List("", opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	commands := make([]Command, 0, len(events))
+	for _, event := range events {
+		if !strings.HasPrefix(event.Name, b.config.Prefix) {
+			continue
+		}
+		var cmd Command
+		if err := json.Unmarshal(event.Payload, &cmd); err != nil {
+			b.client.logger.Printf("Warning: Ignoring invalid command event %s: %v", event.ID, err)
+			continue
+		}
+		cmd.ID = event.ID
+		cmd.Name = strings.TrimPrefix(event.Name, b.config.Prefix)
+		commands = append(commands, cmd)
+	}
+	return commands, meta.LastIndex, nil
+}
+
+// fireKV writes the command under the prefix and prunes commands older than the TTL
+func (b *CommandBus) fireKV(ctx context.Context, name string, cmd Command) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	cmd.ID = hex.EncodeToString(id)
+	cmd.Name = name
+	body, err := json.Marshal(cmd)
+	if err != nil {
+		return "", err
+	}
+	kv := b.client.client.KV()
+	if _, err := kv.Put(&api.KVPair{Key: b.config.Prefix + cmd.ID, Value: body}, (&api.WriteOptions{}).WithContext(ctx)); err != nil {
+		return "", fmt.Errorf("fire command %s: %w", name, err)
+	}
+
+	pairs, _, err := kv.List(b.config.Prefix, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return cmd.ID, nil
+	}
+	for _, pair := range pairs {
+		var old Command
+		if json.Unmarshal(pair.Value, &old) == nil && time.Since(old.FiredAt) <= b.config.TTL {
+			continue
+		}
+		if _, err := kv.Delete(pair.Key, (&api.WriteOptions{}).WithContext(ctx)); err != nil {
+			b.client.logger.Printf("Warning: Failed to prune command %s: %v", pair.Key, err)
+		}
+	}
+	return cmd.ID, nil
+}
+
+// markSeen records the command ID and reports whether it was new
+func (b *CommandBus) markSeen(id string) bool {
+	b.seenMu.Lock()
+	defer b.seenMu.Unlock()
+	if _, ok := b.seen[id]; ok {
+		return false
+	}
+	b.seen[id] = struct{}{}
+	b.seenOrder = append(b.seenOrder, id)
+	if len(b.seenOrder) > seenCommandsSize {
+		delete(b.seen, b.seenOrder[0])
+		b.seenOrder = b.seenOrder[1:]
+	}
+	return true
+}
+
+func (b *CommandBus) execute(ctx context.Context, cmd Command) {
+	b.mu.RLock()
+	handler, ok := b.handlers[cmd.Name]
+	b.mu.RUnlock()
+	if !ok {
+		b.client.logger.Printf("Warning: No handler for command %s (%s) from %s", cmd.Name, cmd.ID, cmd.Origin)
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			b.client.logger.Printf("Warning: Command %s (%s) panicked: %v", cmd.Name, cmd.ID, r)
+		}
+	}()
+	if err := handler(ctx, cmd); err != nil {
+		b.client.logger.Printf("Warning: Command %s (%s) from %s failed: %v", cmd.Name, cmd.ID, cmd.Origin, err)
+		return
+	}
+	b.client.logger.Printf("Executed command %s (%s) from %s", cmd.Name, cmd.ID, cmd.Origin)
+}
+
+func (b *CommandBus) clearCache(ctx context.Context, cmd Command) error {
+	if fxcache.FxCache == nil {
+		return fmt.Errorf("fxcache is not initialized")
+	}
+	fxcache.Clear()
+	return nil
+}
+
+func (b *CommandBus) refreshConfig(ctx context.Context, cmd Command) error {
+	b.client.RefreshCache()
+	return nil
+}
+
+// restoreLogLevel cancels the pending restore of a temporary log level and applies it immediately
+func (b *CommandBus) restoreLogLevel() {
+	b.levelMu.Lock()
+	defer b.levelMu.Unlock()
+	if b.levelTimer == nil {
+		return
+	}
+	b.levelTimer.Stop()
+	b.levelTimer = nil
+	b.levelGen++
+	b.config.LogLevel.Set(b.levelRestore)
+	b.client.logger.Printf("Log level restored to %s", b.levelRestore)
+}
+
+func (b *CommandBus) setLogLevel(ctx context.Context, cmd Command) error {
+	var payload LogLevelPayload
+	if err := cmd.Decode(&payload); err != nil {
+		return err
+	}
+	var duration time.Duration
+	if payload.Duration != "" {
+		parsed, err := time.ParseDuration(payload.Duration)
+		if err != nil {
+			return err
+		}
+		duration = parsed
+	}
+
+	b.levelMu.Lock()
+	defer b.levelMu.Unlock()
+	levelVar := b.config.LogLevel
+	level := slog.LevelDebug
+	if payload.Level != "" {
+		if err := level.UnmarshalText([]byte(payload.Level)); err != nil {
+			return err
+		}
+	} else if levelVar.Level() == slog.LevelDebug {
+		level = slog.LevelInfo
+	}
+	// A pending restore keeps the level from before the first temporary change
+	restore := levelVar.Level()
+	if b.levelTimer != nil {
+		b.levelTimer.Stop()
+		b.levelTimer = nil
+		restore = b.levelRestore
+	}
+	levelVar.Set(level)
+	b.levelGen++
+	b.client.logger.Printf("Log level set to %s by %s", level, cmd.Origin)
+	if duration > 0 {
+		generation := b.levelGen
+		b.levelRestore = restore
+		b.levelTimer = time.AfterFunc(duration, func() {
+			b.levelMu.Lock()
+			defer b.levelMu.Unlock()
+			if b.levelGen != generation {
+				return // Stop came too late: the level was changed again meanwhile
+			}
+			levelVar.Set(restore)
+			b.levelTimer = nil
+			b.client.logger.Printf("Log level restored to %s", restore)
+		})
+	}
+	return nil
+}
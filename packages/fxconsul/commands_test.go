@@ -0,0 +1,196 @@
+package fxconsul_test
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tacjlee/common-sdk/packages/fxconsul"
+	"github.com/tacjlee/common-sdk/packages/fxconsul/consultest"
+)
+
+// startBus starts a command bus that reports the commands it executes on the returned channel
+func startBus(t *testing.T, client *fxconsul.ConsulClient, config fxconsul.CommandBusConfig) (*fxconsul.CommandBus, <-chan fxconsul.Command) {
+	t.Helper()
+	bus := client.NewCommandBus(config)
+	executed := make(chan fxconsul.Command, 16)
+	bus.Handle("greet", func(ctx context.Context, cmd fxconsul.Command) error {
+		executed <- cmd
+		return nil
+	})
+	if err := bus.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(bus.Stop)
+	return bus, executed
+}
+
+func receiveCommand(t *testing.T, executed <-chan fxconsul.Command) fxconsul.Command {
+	t.Helper()
+	select {
+	case cmd := <-executed:
+		return cmd
+	case <-time.After(waitTimeout):
+		t.Fatal("no command executed")
+		return fxconsul.Command{}
+	}
+}
+
+func expectNoCommand(t *testing.T, executed <-chan fxconsul.Command) {
+	t.Helper()
+	select {
+	case cmd := <-executed:
+		t.Fatalf("unexpected command %+v", cmd)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestKVCommandBusDeliversOncePerReplica(t *testing.T) {
+	server := consultest.NewServer(t)
+	config := fxconsul.CommandBusConfig{Transport: fxconsul.CommandTransportKV}
+	sender, senderExecuted := startBus(t, newClient(t, server), config)
+	_, replicaExecuted := startBus(t, newClient(t, server), config)
+
+	// Payloads are not limited to the size of a user event
+	payload := map[string]string{"name": strings.Repeat("x", 1024)}
+	id, err := sender.Fire(context.Background(), "greet", payload)
+	if err != nil {
+		t.Fatalf("Fire: %v", err)
+	}
+	for _, executed := range []<-chan fxconsul.Command{senderExecuted, replicaExecuted} {
+		cmd := receiveCommand(t, executed)
+		var decoded map[string]string
+		if cmd.ID != id || cmd.Name != "greet" || cmd.Decode(&decoded) != nil || len(decoded["name"]) != 1024 {
+			t.Errorf("executed %+v, want greet %s with its payload", cmd, id)
+		}
+	}
+
+	// Later writes under the prefix do not replay the command
+	if _, err := sender.Fire(context.Background(), "other", nil); err != nil {
+		t.Fatalf("Fire: %v", err)
+	}
+	expectNoCommand(t, senderExecuted)
+	expectNoCommand(t, replicaExecuted)
+}
+
+func TestKVCommandBusIgnoresCommandsFiredBeforeStart(t *testing.T) {
+	server := consultest.NewServer(t)
+	config := fxconsul.CommandBusConfig{Transport: fxconsul.CommandTransportKV}
+	sender, _ := startBus(t, newClient(t, server), config)
+	if _, err := sender.Fire(context.Background(), "greet", nil); err != nil {
+		t.Fatalf("Fire: %v", err)
+	}
+
+	_, executed := startBus(t, newClient(t, server), config)
+	expectNoCommand(t, executed)
+}
+
+func TestKVCommandsAreNotConfigurationChanges(t *testing.T) {
+	server := consultest.NewServer(t)
+	client := newClient(t, server)
+	changes := subscribe(client, "")
+	watch(t, server, client)
+	bus, executed := startBus(t, client, fxconsul.CommandBusConfig{Transport: fxconsul.CommandTransportKV})
+
+	if _, err := bus.Fire(context.Background(), "greet", nil); err != nil {
+		t.Fatalf("Fire: %v", err)
+	}
+	receiveCommand(t, executed)
+	expectNone(t, changes)
+}
+
+func TestEventCommandBusDoesNotWakeKVQueries(t *testing.T) {
+	server := consultest.NewServer(t)
+	bus, executed := startBus(t, newClient(t, server), fxconsul.CommandBusConfig{})
+	index := server.Index()
+
+	if _, err := bus.Fire(context.Background(), "greet", nil); err != nil {
+		t.Fatalf("Fire: %v", err)
+	}
+	if cmd := receiveCommand(t, executed); cmd.Name != "greet" {
+		t.Errorf("executed %+v, want greet", cmd)
+	}
+	if server.Index() != index {
+		t.Errorf("firing an event moved the KV index from %d to %d", index, server.Index())
+	}
+
+	if _, err := bus.Fire(context.Background(), "greet", map[string]string{"name": strings.Repeat("x", 1024)}); err == nil {
+		t.Error("Fire accepted a command larger than a user event")
+	}
+}
+
+func TestSetLogLevelCommand(t *testing.T) {
+	server := consultest.NewServer(t)
+	level := new(slog.LevelVar)
+	bus := newClient(t, server).NewCommandBus(fxconsul.CommandBusConfig{Transport: fxconsul.CommandTransportKV, LogLevel: level})
+	if err := bus.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer bus.Stop()
+
+	// A temporary level is restored once its duration elapses
+	if _, err := bus.Fire(context.Background(), fxconsul.CommandSetLogLevel, fxconsul.LogLevelPayload{Level: "debug", Duration: "100ms"}); err != nil {
+		t.Fatalf("Fire: %v", err)
+	}
+	eventually(t, func() bool { return level.Level() == slog.LevelDebug }, "the level was not set to debug")
+	eventually(t, func() bool { return level.Level() == slog.LevelInfo }, "the level was not restored to info")
+
+	// A permanent level replaces a pending restore
+	if _, err := bus.Fire(context.Background(), fxconsul.CommandSetLogLevel, fxconsul.LogLevelPayload{Level: "debug", Duration: "100ms"}); err != nil {
+		t.Fatalf("Fire: %v", err)
+	}
+	eventually(t, func() bool { return level.Level() == slog.LevelDebug }, "the level was not set to debug")
+	if _, err := bus.Fire(context.Background(), fxconsul.CommandSetLogLevel, fxconsul.LogLevelPayload{Level: "warn"}); err != nil {
+		t.Fatalf("Fire: %v", err)
+	}
+	eventually(t, func() bool { return level.Level() == slog.LevelWarn }, "the level was not set to warn")
+	time.Sleep(200 * time.Millisecond)
+	if got := level.Level(); got != slog.LevelWarn {
+		t.Errorf("level = %s after the old restore was due, want WARN", got)
+	}
+}
+
+func TestStopRevertsATemporaryLogLevel(t *testing.T) {
+	server := consultest.NewServer(t)
+	level := new(slog.LevelVar)
+	bus := newClient(t, server).NewCommandBus(fxconsul.CommandBusConfig{Transport: fxconsul.CommandTransportKV, LogLevel: level})
+	if err := bus.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if _, err := bus.Fire(context.Background(), fxconsul.CommandSetLogLevel, fxconsul.LogLevelPayload{Level: "debug", Duration: "100ms"}); err != nil {
+		t.Fatalf("Fire: %v", err)
+	}
+	eventually(t, func() bool { return level.Level() == slog.LevelDebug }, "the level was not set to debug")
+
+	bus.Stop()
+	if got := level.Level(); got != slog.LevelInfo {
+		t.Errorf("level = %s after Stop, want INFO", got)
+	}
+	level.Set(slog.LevelWarn)
+	time.Sleep(200 * time.Millisecond)
+	if got := level.Level(); got != slog.LevelWarn {
+		t.Errorf("level = %s after the stopped bus restore was due, want WARN", got)
+	}
+}
+
+func TestConcurrentStartAndStop(t *testing.T) {
+	server := consultest.NewServer(t)
+	bus := newClient(t, server).NewCommandBus(fxconsul.CommandBusConfig{Transport: fxconsul.CommandTransportKV})
+	started := make(chan error, 2)
+	for range 2 {
+		go func() { started <- bus.Start(context.Background()) }()
+	}
+	go bus.Stop()
+	failures := 0
+	for range 2 {
+		if err := <-started; err != nil {
+			failures++
+		}
+	}
+	bus.Stop()
+	if failures != 1 {
+		t.Errorf("%d of two concurrent Start calls failed, want 1", failures)
+	}
+}
@@ -0,0 +1,263 @@
+package fxconsul
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConnectionState is the state of the client's connection to Consul, modelled as a circuit breaker
+type ConnectionState int32
+
+const (
+	// StateDisabled means Consul is not configured; settings come from the environment only
+	StateDisabled ConnectionState = iota
+	// StateConnected means the circuit is closed and lookups go to Consul
+	StateConnected
+	// StateOpen means Consul failed repeatedly; lookups skip it until the next reconnect attempt
+	StateOpen
+	// StateHalfOpen means a reconnect probe is in flight
+	StateHalfOpen
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisabled:
+		return "disabled"
+	case StateConnected:
+		return "connected"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("ConnectionState(%d)", int32(s))
+}
+
+// StateChangeCallback is called after the connection state changed. It must not block.
+type StateChangeCallback func(old ConnectionState, new ConnectionState)
+
+// ClientMetrics are the runtime metrics of the client
+type ClientMetrics struct {
+	State               string        `json:"state"`
+	ConsecutiveFailures int           `json:"consecutiveFailures"`
+	ReconnectCount      uint64        `json:"reconnectCount"`
+	NextRetryIn         time.Duration `json:"nextRetryIn"`
+	CacheHits           uint64        `json:"cacheHits"`
+	CacheMisses         uint64        `json:"cacheMisses"`
+	CacheHitRatio       float64       `json:"cacheHitRatio"`
+	CoalescedLookups    uint64        `json:"coalescedLookups"` // Cache misses that joined a lookup in flight
+	RefreshAheads       uint64        `json:"refreshAheads"`
+	StaleServed         uint64        `json:"staleServed"` // Expired values served while Consul failed
+	WatchLag            time.Duration `json:"watchLag"`    // Time since the watcher last heard from Consul
+	LastIndex           uint64        `json:"lastIndex"`
+}
+
+// WithBackoff sets the reconnect delay: it starts at initial and doubles up to max, with jitter
+func WithBackoff(initial time.Duration, max time.Duration) Option {
+	return func(o *clientOptions) {
+		o.initialBackoff = initial
+		o.maxBackoff = max
+	}
+}
+
+// WithFailureThreshold sets the number of consecutive failures that open the circuit
+func WithFailureThreshold(threshold int) Option {
+	return func(o *clientOptions) {
+		o.failureThreshold = threshold
+	}
+}
+
+// State returns the current connection state
+func (c *ConsulClient) State() ConnectionState {
+	return ConnectionState(c.conn.state.Load())
+}
+
+// OnStateChange registers a callback invoked on every connection state transition
+func (c *ConsulClient) OnStateChange(callback StateChangeCallback) {
+	c.conn.listenersMu.Lock()
+	defer c.conn.listenersMu.Unlock()
+	c.conn.listeners = append(c.conn.listeners, callback)
+}
+
+// Metrics returns a snapshot of the client metrics
+func (c *ConsulClient) Metrics() ClientMetrics {
+	metrics := ClientMetrics{
+		State:               c.State().String(),
+		ConsecutiveFailures: int(c.conn.failures.Load()),
+		ReconnectCount:      c.conn.reconnects.Load(),
+		CacheHits:           c.cacheHits.Load(),
+		CacheMisses:         c.cacheMisses.Load(),
+		CoalescedLookups:    c.coalesced.Load(),
+		RefreshAheads:       c.refreshAheads.Load(),
+		StaleServed:         c.staleServed.Load(),
+		LastIndex:           c.lastIndex.Load(),
+	}
+	if total := metrics.CacheHits + metrics.CacheMisses; total > 0 {
+		metrics.CacheHitRatio = float64(metrics.CacheHits) / float64(total)
+	}
+	if c.State() == StateOpen {
+		metrics.NextRetryIn = max(time.Until(time.Unix(0, c.conn.nextAttempt.Load())), 0)
+	}
+	if lastWatch := c.lastWatchAt.Load(); lastWatch > 0 {
+		metrics.WatchLag = time.Since(time.Unix(0, lastWatch))
+	}
+	return metrics
+}
+
+// MetricsHandler exposes the client metrics in the Prometheus text format
+func (c *ConsulClient) MetricsHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		metrics := c.Metrics()
+		var sb strings.Builder
+		writeMetric := func(name string, kind string, help string, value any) {
+			fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+		}
+		sb.WriteString("# HELP consul_client_state Connection state of the Consul client, 1 for the current state.\n# TYPE consul_client_state gauge\n")
+		for _, state := range []ConnectionState{StateDisabled, StateConnected, StateOpen, StateHalfOpen} {
+			value := 0
+			if c.State() == state {
+				value = 1
+			}
+			fmt.Fprintf(&sb, "consul_client_state{state=%q} %d\n", state.String(), value)
+		}
+		writeMetric("consul_client_consecutive_failures", "gauge", "Consecutive failed Consul requests.", metrics.ConsecutiveFailures)
+		writeMetric("consul_client_reconnects_total", "counter", "Successful reconnections to Consul.", metrics.ReconnectCount)
+		writeMetric("consul_client_cache_hits_total", "counter", "Settings served from the cache.", metrics.CacheHits)
+		writeMetric("consul_client_cache_misses_total", "counter", "Settings not found in the cache.", metrics.CacheMisses)
+		writeMetric("consul_client_cache_hit_ratio", "gauge", "Ratio of settings served from the cache.", metrics.CacheHitRatio)
+		writeMetric("consul_client_coalesced_lookups_total", "counter", "Cache misses that joined a Consul lookup in flight.", metrics.CoalescedLookups)
+		writeMetric("consul_client_refresh_ahead_total", "counter", "Cached settings refreshed before expiry.", metrics.RefreshAheads)
+		writeMetric("consul_client_stale_served_total", "counter", "Expired settings served because Consul failed.", metrics.StaleServed)
+		writeMetric("consul_client_watch_lag_seconds", "gauge", "Time since the watcher last heard from Consul.", metrics.WatchLag.Seconds())
+		writeMetric("consul_client_watch_index", "gauge", "Last Consul index observed by the watcher.", metrics.LastIndex)
+		ctx.Data(http.StatusOK, "text/plain; version=0.0.4", []byte(sb.String()))
+	}
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+
+// connection holds the circuit breaker state; every field is safe for concurrent use
+type connection struct {
+	state       atomic.Int32
+	failures    atomic.Int32
+	reconnects  atomic.Uint64
+	nextAttempt atomic.Int64 // Unix nanoseconds of the next reconnect attempt while open
+
+	threshold      int32
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	backoffMu      sync.Mutex
+	backoff        time.Duration
+
+	listeners   []StateChangeCallback
+	listenersMu sync.RWMutex
+}
+
+// setState moves to the new state and notifies listeners when it changed
+func (c *ConsulClient) setState(state ConnectionState) {
+	if old := ConnectionState(c.conn.state.Swap(int32(state))); old != state {
+		c.notifyStateChange(old, state)
+	}
+}
+
+// allowRequest reports whether a per-call lookup may go to Consul. While the circuit is open and
+// the backoff has elapsed, a reconnect probe is started in the background.
+func (c *ConsulClient) allowRequest() bool {
+	if c.client == nil {
+		return false
+	}
+	switch c.State() {
+	case StateConnected:
+		return true
+	case StateOpen:
+		if c.retryDue() {
+			go c.tryReconnect()
+		}
+	}
+	return false
+}
+
+func (c *ConsulClient) recordSuccess() {
+	c.conn.failures.Store(0)
+}
+
+// recordFailure opens the circuit once the failure threshold is reached
+func (c *ConsulClient) recordFailure(err error) {
+	failures := c.conn.failures.Add(1)
+	if c.State() == StateConnected && failures >= c.conn.threshold {
+		c.logger.Printf("Warning: Consul failed %d times in a row, using cached values until it recovers: %v", failures, err)
+		c.openCircuit()
+	}
+}
+
+// openCircuit schedules the next reconnect attempt with exponential backoff, jittered within [backoff/2, backoff]
+func (c *ConsulClient) openCircuit() {
+	c.conn.backoffMu.Lock()
+	if c.conn.backoff == 0 {
+		c.conn.backoff = c.conn.initialBackoff
+	} else {
+		c.conn.backoff = min(c.conn.backoff*2, c.conn.maxBackoff)
+	}
+	delay := c.conn.backoff/2 + time.Duration(rand.Int64N(int64(c.conn.backoff/2)+1))
+	c.conn.backoffMu.Unlock()
+
+	c.conn.nextAttempt.Store(time.Now().Add(delay).UnixNano())
+	c.setState(StateOpen)
+}
+
+// closeCircuit resets the backoff after a successful connection
+func (c *ConsulClient) closeCircuit() {
+	c.conn.backoffMu.Lock()
+	c.conn.backoff = 0
+	c.conn.backoffMu.Unlock()
+	c.conn.failures.Store(0)
+	c.setState(StateConnected)
+}
+
+func (c *ConsulClient) retryDue() bool {
+	return time.Now().UnixNano() >= c.conn.nextAttempt.Load()
+}
+
+// retryDelay is how long a background loop should wait before trying again
+func (c *ConsulClient) retryDelay() time.Duration {
+	if c.State() == StateOpen {
+		return max(time.Until(time.Unix(0, c.conn.nextAttempt.Load())), 10*time.Millisecond)
+	}
+	return c.conn.initialBackoff
+}
+
+// tryReconnect probes the agent when the circuit is open. Only one probe runs at a time.
+func (c *ConsulClient) tryReconnect() {
+	if c.client == nil || !c.conn.state.CompareAndSwap(int32(StateOpen), int32(StateHalfOpen)) {
+		return
+	}
+	c.notifyStateChange(StateOpen, StateHalfOpen)
+
+	if _, err := c.client.Agent().Self(); err != nil {
+		c.openCircuit()
+		return
+	}
+	c.lastIndex.Store(0) // Reset index on reconnect
+	c.conn.reconnects.Add(1)
+	c.closeCircuit()
+	c.logger.Printf("Consul connection restored")
+}
+
+func (c *ConsulClient) notifyStateChange(old ConnectionState, state ConnectionState) {
+	c.conn.listenersMu.RLock()
+	listeners := make([]StateChangeCallback, len(c.conn.listeners))
+	copy(listeners, c.conn.listeners)
+	c.conn.listenersMu.RUnlock()
+	for _, listener := range listeners {
+		listener(old, state)
+	}
+}
@@ -1,19 +1,24 @@
 package fxconsul
 
 import (
+	"context"
 	"log"
-	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/consul/api"
 )
 
-// ConfigChangeCallback is called when configuration changes are detected
+// ConfigChangeCallback is called with the keys that were added, modified or deleted
 type ConfigChangeCallback func(changedKeys []string)
 
 type cacheEntry struct {
 	value     string
+	found     bool // false caches a key missing from every layer
 	expiresAt time.Time
 }
 
@@ -22,17 +27,70 @@ type ConsulClient struct {
 	client    *api.Client
 	cache     map[string]cacheEntry
 	cacheMu   sync.RWMutex
+	cacheGen  uint64 // Bumped with cacheMu held whenever entries are invalidated or expired
 	cacheTTL  time.Duration
-	available bool
 	basePath  string
+	layers    []string // KV paths settings are resolved from, most specific first; basePath is layers[0]
+	envs      []string // Environment names, whose subtrees next to an environment layer belong to other profiles
+	logger    Logger
+	snapshots *snapshotStore
+	keyring   *SecretKeyring
+	sources   *LayeredConfig // Precedence GetSetting resolves keys through
+
+	// Connection state and metrics
+	conn        connection
+	cacheHits   atomic.Uint64
+	cacheMisses atomic.Uint64
+	lastWatchAt atomic.Int64 // Unix nanoseconds of the last successful watch query
+
+	// Per-key lookups; flights holds the Consul requests in progress, shared by concurrent misses
+	requestTimeout time.Duration
+	refreshAhead   time.Duration
+	maxStale       time.Duration
+	flights        map[string]*flight
+	flightsMu      sync.Mutex
+	coalesced      atomic.Uint64
+	refreshAheads  atomic.Uint64
+	staleServed    atomic.Uint64
+
+	// Schema validation; pinned holds the last valid value of keys whose update was rejected
+	schema       *ConfigSchema
+	schemaReport SchemaReport
+	schemaMu     sync.Mutex
+	pinned       map[string]pinnedValue
+	pinnedMu     sync.RWMutex
+
+	// Typed getter state; strict fails construction on schema violations
+	strict          bool
+	settingErrors   map[string]*SettingError
+	settingErrorsMu sync.Mutex
+
+	// Change audit; auditBuffer keeps the most recent records for RecentChanges,
+	// auditPending the records not yet handed to the sinks by the audit worker
+	auditSinks   []AuditSink
+	auditHashKey []byte
+	auditBuffer  []ConfigChangeRecord
+	auditPending []ConfigChangeRecord
+	auditWake    chan struct{}
+	auditMu      sync.Mutex
 
 	// Watch-related fields
-	callbacks  []ConfigChangeCallback
-	callbackMu sync.RWMutex
-	stopChan   chan struct{}
-	watching   bool
-	watchMu    sync.Mutex
-	lastIndex  uint64
+	subscriptions   []changeSubscription
+	subscriptionSeq uint64
+	callbackMu      sync.RWMutex
+	watchDone       chan struct{}
+	watchStop       context.CancelFunc
+	watching        bool
+	watchMu         sync.Mutex
+	lastIndex       atomic.Uint64
+	lastChangeAt    atomic.Int64       // Unix nanoseconds of the last change delivered to callbacks
+	snapshot        map[string]kvEntry // Last observed values under basePath, keyed by relative key
+	applyMu         sync.Mutex         // Serializes snapshot updates from the watcher and ForceReload
+
+	closers   []closer
+	closerSeq uint64
+	closeMu   sync.Mutex
+	closeOnce sync.Once
 }
 
 var (
@@ -40,332 +98,396 @@ var (
 	consulOnce     sync.Once
 )
 
-// GetConsulClient returns a singleton ConsulClient instance
+// GetConsulClient returns a singleton ConsulClient instance configured from the CONSUL_* environment variables.
+// When the client cannot be created it falls back to environment variables only; the process exits when
+// even that client cannot be created, rather than handing out a nil client.
+// CONSUL_STRICT has no effect here, strict mode only validating against a schema (WithSchema): services relying
+// on strict validation create their client with NewConsulClient.
 func GetConsulClient() *ConsulClient {
 	consulOnce.Do(func() {
-		consulInstance = newConsulClient()
+		client, err := NewConsulClient(optionsFromEnv()...)
+		if err != nil {
+			log.Printf("Warning: Failed to create Consul client: %v", err)
+			if client, err = NewConsulClient(append(optionsFromEnv(), WithEnabled(false))...); err != nil {
+				log.Fatalf("Failed to create the environment variable fallback of the Consul client: %v", err)
+			}
+		}
+		consulInstance = client
 	})
 	return consulInstance
 }
 
-func newConsulClient() *ConsulClient {
-	enabled := os.Getenv("CONSUL_ENABLED")
-	if enabled == "false" {
-		log.Println("Consul is disabled via CONSUL_ENABLED=false")
-		return &ConsulClient{
-			available: false,
-			cache:     make(map[string]cacheEntry),
-			cacheTTL:  60 * time.Second,
-			basePath:  "config/dev/settings",
-			callbacks: make([]ConfigChangeCallback, 0),
-		}
+// NewConsulClient creates a ConsulClient from functional options.
+// An unreachable agent is not an error: the client starts unavailable and falls back to environment variables.
+func NewConsulClient(opts ...Option) (*ConsulClient, error) {
+	options := defaultClientOptions()
+	for _, opt := range opts {
+		opt(options)
 	}
 
-	host := os.Getenv("CONSUL_HOST")
-	if host == "" {
-		host = "localhost"
+	c := &ConsulClient{
+		cache:    make(map[string]cacheEntry),
+		cacheTTL: options.cacheTTL,
+		basePath: options.basePath,
+		layers:   options.layers,
+		envs:     options.environments,
+		logger:   options.logger,
+		keyring:  options.keyring,
+
+		requestTimeout: max(options.requestTimeout, 10*time.Millisecond),
+		refreshAhead:   min(options.refreshAhead, options.cacheTTL/2),
+		maxStale:       options.maxStale,
+		flights:        make(map[string]*flight),
+
+		schema:        options.schema,
+		pinned:        make(map[string]pinnedValue),
+		settingErrors: make(map[string]*SettingError),
+
+		auditSinks:   options.auditSinks,
+		auditHashKey: options.auditHashKey,
 	}
-
-	port := os.Getenv("CONSUL_PORT")
-	if port == "" {
-		port = "8500"
+	c.sources = NewLayeredConfig(DefaultSources(c)...)
+	c.strict = options.strict
+	c.conn.threshold = int32(max(options.failureThreshold, 1))
+	c.conn.initialBackoff = max(options.initialBackoff, 10*time.Millisecond)
+	c.conn.maxBackoff = max(options.maxBackoff, c.conn.initialBackoff)
+	if len(c.layers) == 0 {
+		c.layers = []string{c.basePath}
+	}
+	if !options.enabled {
+		c.logger.Printf("Consul is disabled, settings are read from environment variables")
+		return c.completeStartup()
 	}
 
-	ttlSeconds := os.Getenv("CONSUL_CACHE_TTL")
-	cacheTTL := 60 * time.Second
-	if ttlSeconds != "" {
-		if parsed, err := time.ParseDuration(ttlSeconds + "s"); err == nil {
-			cacheTTL = parsed
-		}
+	client, err := api.NewClient(options.config)
+	if err != nil {
+		return nil, err
+	}
+	c.client = client
+	if c.snapshots, err = newSnapshotStore(options.snapshotFile, options.snapshotKey); err != nil {
+		return nil, err
 	}
 
-	basePath := os.Getenv("CONSUL_BASE_PATH")
-	if basePath == "" {
-		basePath = "config/dev/settings"
+	// Test connection
+	if _, err = client.Agent().Self(); err != nil {
+		c.logger.Printf("Warning: Consul is not reachable at %s - falling back to local snapshot and environment variables: %v", options.config.Address, err)
+		c.restoreSnapshot()
+		c.openCircuit()
+		return c.completeStartup()
 	}
 
-	config := api.DefaultConfig()
-	config.Address = host + ":" + port
+	c.setState(StateConnected)
+	c.logger.Printf("Consul connected successfully at %s", options.config.Address)
+	c.primeSnapshot()
+	return c.completeStartup()
+}
 
-	client, err := api.NewClient(config)
-	if err != nil {
-		log.Printf("Warning: Failed to create Consul client: %v", err)
-		return &ConsulClient{
-			available: false,
-			cache:     make(map[string]cacheEntry),
-			cacheTTL:  cacheTTL,
-			basePath:  basePath,
-			callbacks: make([]ConfigChangeCallback, 0),
-		}
+// completeStartup validates the schema once the client is constructed and only then starts the background
+// workers, so that a failed construction leaves nothing running
+func (c *ConsulClient) completeStartup() (*ConsulClient, error) {
+	if err := c.validateAtStartup(); err != nil {
+		return nil, err
 	}
+	c.startAuditWorker()
+	return c, nil
+}
 
-	// Test connection
-	_, err = client.Agent().Self()
-	if err != nil {
-		log.Printf("Warning: Consul is not reachable at %s:%s - falling back to environment variables: %v", host, port, err)
-		return &ConsulClient{
-			client:    client,
-			available: false,
-			cache:     make(map[string]cacheEntry),
-			cacheTTL:  cacheTTL,
-			basePath:  basePath,
-			callbacks: make([]ConfigChangeCallback, 0),
+// Close stops the configuration watch and every background worker started by the client
+func (c *ConsulClient) Close() {
+	c.closeOnce.Do(func() {
+		c.StopWatch()
+		c.closeMu.Lock()
+		closers := c.closers
+		c.closers = nil
+		c.closeMu.Unlock()
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i].fn()
 		}
-	}
+	})
+}
 
-	log.Printf("Consul connected successfully at %s:%s", host, port)
-	return &ConsulClient{
-		client:    client,
-		available: true,
-		cache:     make(map[string]cacheEntry),
-		cacheTTL:  cacheTTL,
-		basePath:  basePath,
-		callbacks: make([]ConfigChangeCallback, 0),
+// closer is a function run by Close
+type closer struct {
+	id uint64
+	fn func()
+}
+
+// onClose registers a function run by Close, in reverse registration order.
+// The returned function unregisters it, for workers stopped before the client is closed.
+func (c *ConsulClient) onClose(fn func()) func() {
+	c.closeMu.Lock()
+	defer c.closeMu.Unlock()
+	c.closerSeq++
+	id := c.closerSeq
+	c.closers = append(c.closers, closer{id: id, fn: fn})
+	return func() {
+		c.closeMu.Lock()
+		defer c.closeMu.Unlock()
+		c.closers = slices.DeleteFunc(c.closers, func(registered closer) bool {
+			return registered.id == id
+		})
 	}
 }
 
-// IsAvailable returns whether Consul is reachable
+// IsAvailable returns whether Consul is reachable, i.e. the connection circuit is closed
 func (c *ConsulClient) IsAvailable() bool {
-	return c.available
+	return c.State() == StateConnected
 }
 
-// OnConfigChange registers a callback to be invoked when configuration changes
+// OnConfigChange registers a callback to be invoked with the changed keys when configuration changes
 func (c *ConsulClient) OnConfigChange(callback ConfigChangeCallback) {
-	c.callbackMu.Lock()
-	defer c.callbackMu.Unlock()
-	c.callbacks = append(c.callbacks, callback)
+	c.OnKeyChange("", func(changes ChangeSet) {
+		callback(changes.Keys())
+	})
 }
 
 // WatchConfig starts watching for configuration changes in Consul
 // This runs in a background goroutine and calls registered callbacks when changes are detected
 func (c *ConsulClient) WatchConfig() {
+	if c.client == nil {
+		c.logger.Printf("Consul is disabled, configuration changes are not watched")
+		return
+	}
 	c.watchMu.Lock()
 	if c.watching {
 		c.watchMu.Unlock()
-		log.Println("Config watch already running")
+		c.logger.Printf("Config watch already running")
 		return
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
 	c.watching = true
-	c.stopChan = make(chan struct{})
+	c.watchStop = cancel
+	c.watchDone = done
 	c.watchMu.Unlock()
 
-	go c.watchLoop()
-	log.Println("Started watching Consul configuration changes")
+	go func() {
+		defer close(done)
+		c.watchLoop(ctx)
+	}()
+	c.logger.Printf("Started watching Consul configuration changes")
 }
 
-// StopWatch stops the configuration watch goroutine
+// StopWatch stops the configuration watch goroutine, cancelling its pending Consul query, and waits for it
+// to exit: no change is applied or notified by the watch once it returns
 func (c *ConsulClient) StopWatch() {
 	c.watchMu.Lock()
-	defer c.watchMu.Unlock()
-
 	if !c.watching {
+		c.watchMu.Unlock()
 		return
 	}
-
-	close(c.stopChan)
+	stop, done := c.watchStop, c.watchDone
 	c.watching = false
-	log.Println("Stopped watching Consul configuration changes")
-}
-
-func (c *ConsulClient) watchLoop() {
-	retryInterval := 5 * time.Second
+	c.watchMu.Unlock()
 
-	for {
-		select {
-		case <-c.stopChan:
-			return
-		default:
-		}
+	stop()
+	<-done
+	c.logger.Printf("Stopped watching Consul configuration changes")
+}
 
-		if !c.available || c.client == nil {
-			// Try to reconnect
-			c.tryReconnect()
-			select {
-			case <-c.stopChan:
+func (c *ConsulClient) watchLoop(ctx context.Context) {
+	for ctx.Err() == nil {
+		if !c.IsAvailable() {
+			// Probe once the backoff has elapsed, otherwise wait for it
+			if c.retryDue() {
+				c.tryReconnect()
+			}
+			if !c.IsAvailable() && !c.waitRetry(ctx) {
 				return
-			case <-time.After(retryInterval):
-				continue
 			}
+			continue
 		}
 
-		// Use blocking query to watch for changes
-		opts := &api.QueryOptions{
-			WaitIndex: c.lastIndex,
-			WaitTime:  30 * time.Second, // Long poll timeout
+		// Use blocking queries to watch for changes
+		lastIndex := c.lastIndex.Load()
+		pairs, index, err := c.listLayers(ctx, lastIndex)
+		if ctx.Err() != nil {
+			return
 		}
-
-		pairs, meta, err := c.client.KV().List(c.basePath, opts)
 		if err != nil {
-			log.Printf("Warning: Error watching Consul KV: %v", err)
-			c.available = false
-			select {
-			case <-c.stopChan:
+			c.logger.Printf("Warning: Error watching Consul KV: %v", err)
+			c.recordFailure(err)
+			if !c.waitRetry(ctx) {
 				return
-			case <-time.After(retryInterval):
-				continue
 			}
+			continue
 		}
+		c.recordSuccess()
+		c.lastWatchAt.Store(time.Now().UnixNano())
 
-		// Check if index changed (meaning data changed)
-		if meta.LastIndex != c.lastIndex {
-			if c.lastIndex != 0 { // Skip first iteration
-				changedKeys := make([]string, 0)
-				for _, pair := range pairs {
-					// Extract key name from full path
-					if len(pair.Key) > len(c.basePath)+1 {
-						key := pair.Key[len(c.basePath)+1:] // Remove basePath/ prefix
-						changedKeys = append(changedKeys, key)
-					}
-				}
-
-				log.Printf("Consul configuration changed, refreshing cache")
-				c.RefreshCache()
-				c.notifyCallbacks(changedKeys)
-			}
-			c.lastIndex = meta.LastIndex
+		// Check if index changed (meaning data may have changed)
+		if index < lastIndex {
+			// The index went backwards (e.g. Consul restored from a snapshot): start over
+			c.lastIndex.CompareAndSwap(lastIndex, 0)
+		}
+		if index != lastIndex {
+			c.applyPairs(ctx, pairs, index)
 		}
 	}
 }
 
-func (c *ConsulClient) tryReconnect() {
-	if c.client == nil {
+// applyPairs diffs the listed KV pairs against the last snapshot, then invalidates and notifies the changed keys.
+// Nothing is applied once ctx is done, nor from a listing older than the last one applied.
+func (c *ConsulClient) applyPairs(ctx context.Context, pairs api.KVPairs, index uint64) {
+	c.applyMu.Lock()
+	defer c.applyMu.Unlock()
+	if ctx.Err() != nil || index < c.lastIndex.Load() {
 		return
 	}
 
-	_, err := c.client.Agent().Self()
-	if err == nil {
-		c.available = true
-		c.lastIndex = 0 // Reset index on reconnect
-		log.Println("Consul connection restored")
+	current := c.snapshotFromPairs(pairs)
+	if c.snapshot != nil { // Skip first load, there is nothing to compare against
+		detected := diffSnapshot(c.snapshot, current, index)
+		changes, rejected := c.rejectInvalid(detected, c.snapshot, current)
+		if len(changes.Changes) > 0 {
+			c.logger.Printf("Consul configuration changed (%d keys), refreshing cache", len(changes.Changes))
+			c.lastChangeAt.Store(time.Now().UnixNano())
+			c.invalidateKeys(changes.Keys())
+			c.notifyCallbacks(changes)
+		}
+		c.auditChanges(changes, rejected)
+	}
+	c.snapshot = current
+	c.lastIndex.Store(index)
+	c.saveSnapshot(current, index)
+}
+
+// waitRetry sleeps for the retry delay and reports false when the watch was stopped meanwhile
+func (c *ConsulClient) waitRetry(ctx context.Context) bool {
+	timer := time.NewTimer(c.retryDelay())
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
 	}
 }
 
-func (c *ConsulClient) notifyCallbacks(changedKeys []string) {
+// notifyCallbacks queues the matching changes of every subscription. Each subscription receives its
+// change sets one at a time and in order, without blocking the watch.
+func (c *ConsulClient) notifyCallbacks(changes ChangeSet) {
 	c.callbackMu.RLock()
-	callbacks := make([]ConfigChangeCallback, len(c.callbacks))
-	copy(callbacks, c.callbacks)
+	subscriptions := make([]changeSubscription, len(c.subscriptions))
+	copy(subscriptions, c.subscriptions)
 	c.callbackMu.RUnlock()
 
-	for _, callback := range callbacks {
-		go callback(changedKeys)
+	for _, subscription := range subscriptions {
+		if filtered := changes.filter(subscription.matches); len(filtered.Changes) > 0 {
+			callback := subscription.callback
+			subscription.queue.push(func() { callback(filtered) })
+		}
 	}
 }
 
-// GetSetting retrieves a configuration value with fallback:
+// GetSetting retrieves a configuration value through the client's Sources, by default:
 // 1. Check cache (if not expired)
 // 2. Try Consul KV
-// 3. Fall back to environment variable
-// 4. Return default value
+// 3. Serve the expired cache entry, then the local snapshot, while Consul is unavailable
+// 4. Fall back to environment variable
+// then returns the default value, or the schema default when defaultValue is empty
 func (c *ConsulClient) GetSetting(key string, defaultValue string) string {
-	// Check cache first
-	c.cacheMu.RLock()
-	if entry, ok := c.cache[key]; ok && time.Now().Before(entry.expiresAt) {
-		c.cacheMu.RUnlock()
-		return entry.value
+	return c.GetSettingContext(context.Background(), key, defaultValue)
+}
+
+// lookupConsul reads a key from the cache or Consul KV, without any fallback
+func (c *ConsulClient) lookupConsul(ctx context.Context, key string) (string, bool) {
+	// A rejected update keeps serving the last valid value
+	if value, found, pinned := c.lookupPinned(key); pinned {
+		return value, found
 	}
+
+	// Check cache first, refreshing entries about to expire in the background
+	now := time.Now()
+	c.cacheMu.RLock()
+	entry, ok := c.cache[key]
 	c.cacheMu.RUnlock()
+	if ok && now.Before(entry.expiresAt) {
+		c.cacheHits.Add(1)
+		if entry.expiresAt.Sub(now) < c.refreshAhead {
+			c.refreshInBackground(key)
+		}
+		if !entry.found {
+			return c.lookupSnapshot(key)
+		}
+		return entry.value, true
+	}
+	c.cacheMisses.Add(1)
 
-	// Try Consul if available
-	if c.available && c.client != nil {
-		consulKey := c.basePath + "/" + key
-		pair, _, err := c.client.KV().Get(consulKey, nil)
-		if err != nil {
-			log.Printf("Warning: Failed to get key %s from Consul: %v", consulKey, err)
-			// Mark as unavailable for subsequent calls
-			c.available = false
-		} else if pair != nil && len(pair.Value) > 0 {
-			value := string(pair.Value)
-			// Update cache
-			c.cacheMu.Lock()
-			c.cache[key] = cacheEntry{
-				value:     value,
-				expiresAt: time.Now().Add(c.cacheTTL),
+	// Try Consul unless the circuit is open
+	if c.allowRequest() {
+		value, found, err := c.fetch(ctx, key)
+		if err == nil {
+			if found {
+				return value, true
 			}
-			c.cacheMu.Unlock()
-			return value
+			return c.lookupSnapshot(key)
 		}
 	}
-
-	// Fall back to environment variable
-	if envValue := os.Getenv(key); envValue != "" {
-		return envValue
+	if value, ok := c.lookupStale(key); ok {
+		return value, true
 	}
-
-	return defaultValue
+	return c.lookupSnapshot(key)
 }
 
 // GetSettingInt retrieves an integer configuration value
 func (c *ConsulClient) GetSettingInt(key string, defaultValue int) int {
-	strValue := c.GetSetting(key, "")
-	if strValue == "" {
-		return defaultValue
-	}
-
-	var intValue int
-	_, err := parseIntValue(strValue, &intValue)
-	if err != nil {
-		return defaultValue
-	}
-	return intValue
+	return getTyped(c, key, defaultValue, "int", func(raw string) (int, error) {
+		var intValue int
+		return parseIntValue(raw, &intValue)
+	})
 }
 
-// GetSettingBool retrieves a boolean configuration value
+// GetSettingBool retrieves a boolean configuration value (true/false, 1/0, yes/no, case-insensitive)
 func (c *ConsulClient) GetSettingBool(key string, defaultValue bool) bool {
-	strValue := c.GetSetting(key, "")
-	if strValue == "" {
-		return defaultValue
-	}
-
-	switch strValue {
-	case "true", "True", "TRUE", "1", "yes", "Yes", "YES":
-		return true
-	case "false", "False", "FALSE", "0", "no", "No", "NO":
-		return false
-	default:
-		return defaultValue
-	}
+	return getTyped(c, key, defaultValue, "bool", parseBool)
 }
 
+// parseIntValue accepts an optional sign and surrounding whitespace
 func parseIntValue(s string, v *int) (int, error) {
-	var result int
-	negative := false
-	for i, c := range s {
-		if c == '-' && i == 0 {
-			negative = true
-			continue
-		}
-		if c < '0' || c > '9' {
-			return 0, &parseError{s}
-		}
-		result = result*10 + int(c-'0')
-	}
-	if negative {
-		result = -result
+	result, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, err.(*strconv.NumError).Err
 	}
 	*v = result
 	return result, nil
 }
 
-type parseError struct {
-	value string
-}
-
-func (e *parseError) Error() string {
-	return "cannot parse: " + e.value
+// ForceReload re-reads every key from Consul immediately, notifying callbacks of the keys that changed
+// since the last watch update, and clears the cache
+func (c *ConsulClient) ForceReload() error {
+	if c.State() == StateOpen {
+		c.tryReconnect()
+	}
+	if err := c.requireConsul(); err != nil {
+		return err
+	}
+	pairs, index, err := c.listLayers(context.Background(), 0)
+	if err != nil {
+		c.recordFailure(err)
+		return err
+	}
+	c.applyPairs(context.Background(), pairs, index)
+	c.RefreshCache()
+	return nil
 }
 
-// RefreshCache clears the cache to force fresh reads from Consul
+// RefreshCache expires the cache to force fresh reads from Consul. The entries are kept so that they can
+// still be served stale while Consul cannot be reached.
 func (c *ConsulClient) RefreshCache() {
+	now := time.Now()
 	c.cacheMu.Lock()
-	c.cache = make(map[string]cacheEntry)
+	for key, entry := range c.cache {
+		if entry.expiresAt.After(now) {
+			entry.expiresAt = now
+			c.cache[key] = entry
+		}
+	}
+	c.cacheGen++
 	c.cacheMu.Unlock()
 
-	// Retry connection if previously unavailable
-	if !c.available && c.client != nil {
-		_, err := c.client.Agent().Self()
-		if err == nil {
-			c.available = true
-			log.Println("Consul connection restored")
-		}
+	// Retry connection immediately if previously unavailable
+	if c.State() == StateOpen {
+		c.tryReconnect()
 	}
 }
@@ -0,0 +1,157 @@
+package fxconsul_test
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/tacjlee/common-sdk/packages/fxconsul"
+	"github.com/tacjlee/common-sdk/packages/fxconsul/consultest"
+)
+
+const waitTimeout = 5 * time.Second
+
+var discardLogger = log.New(io.Discard, "", 0)
+
+// newClient connects a client to the fake agent with the logs discarded
+func newClient(t *testing.T, server *consultest.Server, opts ...fxconsul.Option) *fxconsul.ConsulClient {
+	t.Helper()
+	return server.Client(t, append([]fxconsul.Option{fxconsul.WithLogger(discardLogger)}, opts...)...)
+}
+
+// watch starts the configuration watch and waits until it is parked on the agent
+func watch(t *testing.T, server *consultest.Server, client *fxconsul.ConsulClient) {
+	t.Helper()
+	client.WatchConfig()
+	if !server.WaitForBlockingQueries(1, waitTimeout) {
+		t.Fatal("the watch did not start a blocking query")
+	}
+}
+
+// subscribe collects the change sets of a key or prefix
+func subscribe(client *fxconsul.ConsulClient, keyOrPrefix string) <-chan fxconsul.ChangeSet {
+	changes := make(chan fxconsul.ChangeSet, 16)
+	client.OnKeyChange(keyOrPrefix, func(cs fxconsul.ChangeSet) {
+		changes <- cs
+	})
+	return changes
+}
+
+func receive(t *testing.T, changes <-chan fxconsul.ChangeSet) fxconsul.ChangeSet {
+	t.Helper()
+	select {
+	case cs := <-changes:
+		return cs
+	case <-time.After(waitTimeout):
+		t.Fatal("no change notified")
+		return fxconsul.ChangeSet{}
+	}
+}
+
+func expectNone(t *testing.T, changes <-chan fxconsul.ChangeSet) {
+	t.Helper()
+	select {
+	case cs := <-changes:
+		t.Fatalf("unexpected change %+v", cs)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// eventually polls condition until it holds or the wait times out
+func eventually(t *testing.T, condition func() bool, message string) {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGetSettingReadsConsulThenDefault(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.Set("config/dev/settings/db/host", "db.internal")
+	client := newClient(t, server)
+
+	if got := client.GetSetting("db/host", "localhost"); got != "db.internal" {
+		t.Errorf("GetSetting(db/host) = %q, want db.internal", got)
+	}
+	if got := client.GetSetting("db/port", "5432"); got != "5432" {
+		t.Errorf("GetSetting(db/port) = %q, want the default 5432", got)
+	}
+}
+
+func TestWatchConfigNotifiesChanges(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.Set("config/dev/settings/feature", "off")
+	client := newClient(t, server)
+	if got := client.GetSetting("feature", ""); got != "off" {
+		t.Fatalf("GetSetting(feature) = %q, want off", got)
+	}
+	changes := subscribe(client, "")
+	watch(t, server, client)
+
+	server.Set("config/dev/settings/feature", "on")
+	cs := receive(t, changes)
+	if change, ok := cs.Get("feature"); !ok || change.NewValue != "on" {
+		t.Fatalf("change set %+v does not hold feature=on", cs)
+	}
+	if got := client.GetSetting("feature", ""); got != "on" {
+		t.Errorf("GetSetting(feature) after the change = %q, want on", got)
+	}
+}
+
+func TestStopWatchStopsNotifications(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.Set("config/dev/settings/feature", "off")
+	client := newClient(t, server)
+	changes := subscribe(client, "")
+	watch(t, server, client)
+
+	client.StopWatch()
+	server.Set("config/dev/settings/feature", "on")
+	expectNone(t, changes)
+
+	// A restarted watch catches up with the change made while it was stopped; stopping twice is harmless
+	client.WatchConfig()
+	cs := receive(t, changes)
+	if change, ok := cs.Get("feature"); !ok || change.NewValue != "on" {
+		t.Fatalf("change set %+v does not hold feature=on", cs)
+	}
+	client.StopWatch()
+	client.StopWatch()
+}
+
+func TestCloseStopsWatch(t *testing.T) {
+	server := consultest.NewServer(t)
+	client := newClient(t, server)
+	changes := subscribe(client, "")
+	watch(t, server, client)
+
+	client.Close()
+	client.Close()
+	server.Set("config/dev/settings/feature", "on")
+	expectNone(t, changes)
+}
+
+func TestWatchResumesAfterOutage(t *testing.T) {
+	server := consultest.NewServer(t)
+	client := newClient(t, server, fxconsul.WithBackoff(10*time.Millisecond, 50*time.Millisecond), fxconsul.WithFailureThreshold(1))
+	changes := subscribe(client, "feature")
+	watch(t, server, client)
+
+	server.SetAvailable(false)
+	eventually(t, func() bool { return !client.IsAvailable() }, "the outage was not detected")
+	server.Set("config/dev/settings/feature", "on")
+	server.SetAvailable(true)
+
+	cs := receive(t, changes)
+	if change, ok := cs.Get("feature"); !ok || change.Type != fxconsul.ChangeAdded {
+		t.Fatalf("change set %+v does not add feature", cs)
+	}
+	if !client.IsAvailable() {
+		t.Error("client is not available after the outage")
+	}
+}
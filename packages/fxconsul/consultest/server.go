@@ -0,0 +1,796 @@
+// Package consultest provides an in-process fake Consul agent for unit tests of code built on fxconsul.
+//
+//	server := consultest.NewServer(t)
+//	server.Set("config/dev/settings/db/host", "localhost")
+//	client := server.Client(t)
+//	client.GetSetting("db/host", "")   // "localhost"
+//
+// The server emulates the KV endpoints (get, list, keys, put, delete, CAS, transactions and blocking
+// queries with X-Consul-Index), agent self, agent service registration, TTL checks, the health
+// service endpoint, user events (fire and list) and sessions with KV acquire/release, so that
+// DistributedLock and LeaderElection can be exercised. Session TTLs and lock-delays are honoured;
+// InvalidateSession simulates a lost session.
+package consultest
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/tacjlee/common-sdk/packages/fxconsul"
+)
+
+// maxWait caps the duration of blocking queries
+const maxWait = 10 * time.Second
+
+// maxEvents is the number of user events kept, like the agent's event buffer
+const maxEvents = 256
+
+// Server is a fake Consul agent backed by in-memory state
+type Server struct {
+	httpServer *httptest.Server
+
+	mu         sync.Mutex
+	index      uint64
+	eventIndex uint64 // Index of the event list, kept apart so that events do not release KV blocking queries
+	kv         map[string]*api.KVPair
+	services   map[string]*api.AgentService
+	checks     map[string]*api.HealthCheck
+	events     []*api.UserEvent
+	sessions   map[string]*session
+	lockDelays map[string]time.Time // Keys that cannot be acquired until the lock-delay of their last holder ends
+	changed    chan struct{}        // Closed and replaced on every write to release blocking queries
+	available  bool
+	latency    time.Duration // Delay of every response, once its request was served
+	blocked    int           // Number of blocking queries currently waiting
+}
+
+// NewServer starts a fake agent that is closed when the test ends (t may be nil)
+func NewServer(t testing.TB) *Server {
+	s := &Server{
+		index:      1,
+		eventIndex: 1,
+		kv:         make(map[string]*api.KVPair),
+		services:   make(map[string]*api.AgentService),
+		checks:     make(map[string]*api.HealthCheck),
+		sessions:   make(map[string]*session),
+		lockDelays: make(map[string]time.Time),
+		changed:    make(chan struct{}),
+		available:  true,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/kv/", s.handleKV)
+	mux.HandleFunc("/v1/txn", s.handleTxn)
+	mux.HandleFunc("/v1/agent/self", s.handleAgentSelf)
+	mux.HandleFunc("/v1/agent/services", s.handleAgentServices)
+	mux.HandleFunc("/v1/agent/service/", s.handleAgentService)
+	mux.HandleFunc("/v1/agent/check/update/", s.handleCheckUpdate)
+	mux.HandleFunc("/v1/health/service/", s.handleHealthService)
+	mux.HandleFunc("/v1/event/fire/", s.handleEventFire)
+	mux.HandleFunc("/v1/event/list", s.handleEventList)
+	mux.HandleFunc("/v1/session/", s.handleSession)
+	s.httpServer = httptest.NewServer(s.availability(mux))
+	if t != nil {
+		t.Cleanup(s.Close)
+	}
+	return s
+}
+
+// Close shuts the server down, releasing pending blocking queries
+func (s *Server) Close() {
+	s.mu.Lock()
+	for _, current := range s.sessions {
+		if current.timer != nil {
+			current.timer.Stop()
+		}
+	}
+	s.notifyLocked()
+	s.mu.Unlock()
+	s.httpServer.CloseClientConnections()
+	s.httpServer.Close()
+}
+
+// Address returns the host:port of the server, for fxconsul.WithAddress
+func (s *Server) Address() string {
+	return strings.TrimPrefix(s.httpServer.URL, "http://")
+}
+
+// Client creates a ConsulClient connected to the server, closed when the test ends (t may be nil).
+// Extra options are applied after the address, so WithBasePath, WithProfile, etc. can be passed.
+func (s *Server) Client(t testing.TB, opts ...fxconsul.Option) *fxconsul.ConsulClient {
+	options := append([]fxconsul.Option{fxconsul.WithAddress(s.Address())}, opts...)
+	client, err := fxconsul.NewConsulClient(options...)
+	if err != nil {
+		if t != nil {
+			t.Fatalf("consultest: create client: %v", err)
+		}
+		panic(err)
+	}
+	if t != nil {
+		t.Cleanup(client.Close)
+	}
+	return client
+}
+
+// SetAvailable simulates an outage: while unavailable every request fails with 503
+func (s *Server) SetAvailable(available bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.available = available
+	s.notifyLocked()
+}
+
+// SetLatency delays every response by d, e.g. to let concurrent lookups overlap or a write land while
+// a response is on its way
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// Set writes a key, bumping the index
+func (s *Server) Set(key string, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putLocked(key, []byte(value), 0)
+	s.notifyLocked()
+}
+
+// SetAll writes several keys at a single index
+func (s *Server) SetAll(values map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, value := range values {
+		s.putAtLocked(key, []byte(value), 0, s.index+1)
+	}
+	s.index++
+	s.notifyLocked()
+}
+
+// Delete removes a key
+func (s *Server) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.kv[key]; ok {
+		delete(s.kv, key)
+		s.index++
+		s.notifyLocked()
+	}
+}
+
+// Get returns the value of a key
+func (s *Server) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pair, ok := s.kv[key]
+	if !ok {
+		return "", false
+	}
+	return string(pair.Value), true
+}
+
+// Keys returns every stored key in sorted order
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.kv))
+	for key := range s.kv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Index returns the current raft index of the fake
+func (s *Server) Index() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.index
+}
+
+// WaitForBlockingQueries waits until at least n blocking queries are parked on the server, so that a test
+// can write a key knowing that the watcher will observe it. Returns false on timeout.
+func (s *Server) WaitForBlockingQueries(n int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		blocked := s.blocked
+		s.mu.Unlock()
+		if blocked >= n {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+// Service returns a registered service
+func (s *Server) Service(id string) (*api.AgentService, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	service, ok := s.services[id]
+	if !ok {
+		return nil, false
+	}
+	copied := *service
+	return &copied, true
+}
+
+// CheckStatus returns the status of a check ("passing", "warning" or "critical")
+func (s *Server) CheckStatus(checkID string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	check, ok := s.checks[checkID]
+	if !ok {
+		return "", false
+	}
+	return check.Status, true
+}
+
+// SetCheckStatus changes the status of a check, e.g. to fail an HTTP check that the fake never executes
+func (s *Server) SetCheckStatus(checkID string, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if check, ok := s.checks[checkID]; ok {
+		check.Status = status
+		s.index++
+		s.notifyLocked()
+	}
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+func (s *Server) availability(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		available := s.available
+		s.mu.Unlock()
+		if !available {
+			http.Error(w, "consultest: agent unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		recorder := httptest.NewRecorder()
+		next.ServeHTTP(recorder, r)
+
+		// The response reflects the state when the request was served, however late it arrives
+		s.mu.Lock()
+		latency := s.latency
+		s.mu.Unlock()
+		if latency > 0 {
+			select {
+			case <-time.After(latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		for name, values := range recorder.Header() {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(recorder.Code)
+		_, _ = w.Write(recorder.Body.Bytes())
+	})
+}
+
+func (s *Server) handleKV(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+		s.handleKVGet(w, r, key)
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		flags, _ := strconv.ParseUint(query.Get("flags"), 10, 64)
+		s.mu.Lock()
+		ok := true
+		if query.Has("cas") {
+			cas, _ := strconv.ParseUint(query.Get("cas"), 10, 64)
+			ok = s.casMatchesLocked(key, cas)
+		}
+		switch {
+		case !ok:
+		case query.Has("acquire"):
+			ok = s.acquireLocked(key, body, flags, query.Get("acquire"))
+		case query.Has("release"):
+			ok = s.releaseLocked(key, body, flags, query.Get("release"))
+		default:
+			s.putLocked(key, body, flags)
+		}
+		if ok {
+			s.notifyLocked()
+		}
+		s.writeIndexLocked(w, s.index)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, ok)
+	case http.MethodDelete:
+		s.mu.Lock()
+		ok := true
+		if query.Has("cas") {
+			cas, _ := strconv.ParseUint(query.Get("cas"), 10, 64)
+			ok = s.casMatchesLocked(key, cas)
+		}
+		if ok && s.deleteLocked(key, query.Has("recurse")) {
+			s.index++
+			s.notifyLocked()
+		}
+		s.writeIndexLocked(w, s.index)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, ok)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleKVGet serves get, recurse and keys reads, blocking while ?index= is not behind the current index
+func (s *Server) handleKVGet(w http.ResponseWriter, r *http.Request, key string) {
+	query := r.URL.Query()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.blockLocked(r, &s.index) {
+		http.Error(w, "consultest: agent unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	s.writeIndexLocked(w, s.index)
+
+	switch {
+	case query.Has("keys"):
+		separator := query.Get("separator")
+		seen := make(map[string]bool)
+		keys := make([]string, 0)
+		for _, stored := range s.sortedKeysLocked() {
+			if !strings.HasPrefix(stored, key) {
+				continue
+			}
+			if separator != "" {
+				if i := strings.Index(stored[len(key):], separator); i >= 0 {
+					stored = stored[:len(key)+i+len(separator)]
+				}
+			}
+			if !seen[stored] {
+				seen[stored] = true
+				keys = append(keys, stored)
+			}
+		}
+		if len(keys) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, keys)
+	case query.Has("recurse"):
+		pairs := make([]*api.KVPair, 0)
+		for _, stored := range s.sortedKeysLocked() {
+			if strings.HasPrefix(stored, key) {
+				pairs = append(pairs, s.kv[stored])
+			}
+		}
+		if len(pairs) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, pairs)
+	default:
+		pair, ok := s.kv[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, []*api.KVPair{pair})
+	}
+}
+
+// handleTxn applies KV operations atomically; unsupported verbs roll the transaction back
+func (s *Server) handleTxn(w http.ResponseWriter, r *http.Request) {
+	var ops api.TxnOps
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs api.TxnErrors
+	for i, op := range ops {
+		if op.KV == nil {
+			errs = append(errs, &api.TxnError{OpIndex: i, What: "consultest: only KV operations are supported"})
+			continue
+		}
+		switch op.KV.Verb {
+		case api.KVSet, api.KVDelete, api.KVDeleteTree:
+		case api.KVCAS, api.KVDeleteCAS:
+			if !s.casMatchesLocked(op.KV.Key, op.KV.Index) {
+				errs = append(errs, &api.TxnError{OpIndex: i, What: "current modify index " + strconv.FormatUint(s.modifyIndexLocked(op.KV.Key), 10) + " does not match"})
+			}
+		default:
+			errs = append(errs, &api.TxnError{OpIndex: i, What: "consultest: unsupported verb " + string(op.KV.Verb)})
+		}
+	}
+	if len(errs) > 0 {
+		s.writeIndexLocked(w, s.index)
+		writeJSON(w, http.StatusConflict, api.TxnResponse{Errors: errs})
+		return
+	}
+
+	index := s.index + 1
+	results := make(api.TxnResults, 0, len(ops))
+	for _, op := range ops {
+		switch op.KV.Verb {
+		case api.KVSet, api.KVCAS:
+			s.putAtLocked(op.KV.Key, op.KV.Value, op.KV.Flags, index)
+			results = append(results, &api.TxnResult{KV: s.kv[op.KV.Key]})
+		case api.KVDelete, api.KVDeleteCAS:
+			s.deleteLocked(op.KV.Key, false)
+		case api.KVDeleteTree:
+			s.deleteLocked(op.KV.Key, true)
+		}
+	}
+	s.index = index
+	s.notifyLocked()
+	s.writeIndexLocked(w, s.index)
+	writeJSON(w, http.StatusOK, api.TxnResponse{Results: results})
+}
+
+func (s *Server) handleAgentSelf(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]map[string]any{
+		"Config": {"Datacenter": "dc1", "NodeName": "consultest", "Version": "consultest"},
+		"Member": {"Name": "consultest", "Addr": "127.0.0.1"},
+	})
+}
+
+func (s *Server) handleAgentServices(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusOK, s.services)
+}
+
+// handleAgentService serves /v1/agent/service/register, /deregister/<id> and /<id>
+func (s *Server) handleAgentService(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/agent/service/")
+	switch {
+	case path == "register" && r.Method == http.MethodPut:
+		var registration api.AgentServiceRegistration
+		if err := json.NewDecoder(r.Body).Decode(&registration); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.registerLocked(&registration)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	case strings.HasPrefix(path, "deregister/") && r.Method == http.MethodPut:
+		id := strings.TrimPrefix(path, "deregister/")
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.services[id]; !ok {
+			http.Error(w, "Unknown service ID "+id, http.StatusNotFound)
+			return
+		}
+		delete(s.services, id)
+		for checkID, check := range s.checks {
+			if check.ServiceID == id {
+				delete(s.checks, checkID)
+			}
+		}
+		s.index++
+		s.notifyLocked()
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		service, ok := s.services[path]
+		if !ok {
+			http.Error(w, "unknown service ID: "+path, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, service)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleCheckUpdate(w http.ResponseWriter, r *http.Request) {
+	checkID := strings.TrimPrefix(r.URL.Path, "/v1/agent/check/update/")
+	var update struct {
+		Status string
+		Output string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	check, ok := s.checks[checkID]
+	if !ok {
+		http.Error(w, "Unknown check ID "+checkID, http.StatusNotFound)
+		return
+	}
+	if check.Status != update.Status || check.Output != update.Output {
+		check.Status, check.Output = update.Status, update.Output
+		s.index++
+		s.notifyLocked()
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleHealthService lists instances of a service with their checks, honouring ?passing and ?tag
+func (s *Server) handleHealthService(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/v1/health/service/")
+	query := r.URL.Query()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.blockLocked(r, &s.index) {
+		http.Error(w, "consultest: agent unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	s.writeIndexLocked(w, s.index)
+
+	entries := make([]*api.ServiceEntry, 0)
+	ids := make([]string, 0, len(s.services))
+	for id := range s.services {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		service := s.services[id]
+		if service.Service != name || !hasTags(service.Tags, query["tag"]) {
+			continue
+		}
+		entry := &api.ServiceEntry{
+			Node:    &api.Node{Node: "consultest", Address: "127.0.0.1", Datacenter: "dc1"},
+			Service: service,
+		}
+		passing := true
+		for _, check := range s.checks {
+			if check.ServiceID == id {
+				entry.Checks = append(entry.Checks, check)
+				passing = passing && check.Status == api.HealthPassing
+			}
+		}
+		if query.Has(api.HealthPassing) && !passing {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (s *Server) handleEventFire(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "consultest: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.eventIndex++
+	event := &api.UserEvent{
+		ID:      fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]),
+		Name:    strings.TrimPrefix(r.URL.Path, "/v1/event/fire/"),
+		Payload: payload,
+		LTime:   s.eventIndex,
+	}
+	s.events = append(s.events, event)
+	if len(s.events) > maxEvents {
+		s.events = s.events[len(s.events)-maxEvents:]
+	}
+	s.notifyLocked()
+	writeJSON(w, http.StatusOK, event)
+}
+
+// handleEventList lists the buffered user events, oldest first, honouring ?name
+func (s *Server) handleEventList(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.blockLocked(r, &s.eventIndex) {
+		http.Error(w, "consultest: agent unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	s.writeIndexLocked(w, s.eventIndex)
+	events := make([]*api.UserEvent, 0, len(s.events))
+	for _, event := range s.events {
+		if name == "" || event.Name == name {
+			events = append(events, event)
+		}
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+func (s *Server) registerLocked(registration *api.AgentServiceRegistration) {
+	id := registration.ID
+	if id == "" {
+		id = registration.Name
+	}
+	s.services[id] = &api.AgentService{
+		ID:      id,
+		Service: registration.Name,
+		Tags:    registration.Tags,
+		Meta:    registration.Meta,
+		Address: registration.Address,
+		Port:    registration.Port,
+	}
+	checks := registration.Checks
+	if registration.Check != nil {
+		checks = append(api.AgentServiceChecks{registration.Check}, checks...)
+	}
+	for i, check := range checks {
+		checkID := check.CheckID
+		if checkID == "" {
+			checkID = "service:" + id
+			if len(checks) > 1 {
+				checkID += ":" + strconv.Itoa(i+1)
+			}
+		}
+		status := check.Status
+		if status == "" {
+			// HTTP and TCP checks are never executed by the fake, so they start passing; TTL checks need a heartbeat
+			status = api.HealthPassing
+			if check.TTL != "" {
+				status = api.HealthCritical
+			}
+		}
+		if existing, ok := s.checks[checkID]; ok && existing.ServiceID == id {
+			status = existing.Status
+		}
+		s.checks[checkID] = &api.HealthCheck{
+			Node:        "consultest",
+			CheckID:     checkID,
+			Name:        check.Name,
+			Status:      status,
+			ServiceID:   id,
+			ServiceName: registration.Name,
+			Type:        checkType(check),
+		}
+	}
+	s.index++
+	s.notifyLocked()
+}
+
+// blockLocked waits, with s.mu held, while the request's ?index= is not behind *index (the KV or event index).
+// Returns false when the client went away or the server became unavailable.
+func (s *Server) blockLocked(r *http.Request, index *uint64) bool {
+	waitIndex, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	if waitIndex == 0 || waitIndex < *index {
+		return true
+	}
+	wait := maxWait
+	if parsed, err := time.ParseDuration(r.URL.Query().Get("wait")); err == nil && parsed > 0 && parsed < maxWait {
+		wait = parsed
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	s.blocked++
+	defer func() { s.blocked-- }()
+	for waitIndex >= *index {
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-timer.C:
+			s.mu.Lock()
+			return true
+		case <-r.Context().Done():
+			s.mu.Lock()
+			return false
+		}
+		s.mu.Lock()
+		if !s.available {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) putLocked(key string, value []byte, flags uint64) {
+	s.index++
+	s.putAtLocked(key, value, flags, s.index)
+}
+
+func (s *Server) putAtLocked(key string, value []byte, flags uint64, index uint64) {
+	pair := &api.KVPair{Key: key, Value: value, Flags: flags, CreateIndex: index, ModifyIndex: index}
+	if existing, ok := s.kv[key]; ok {
+		// A plain write keeps the lock on the key
+		pair.CreateIndex, pair.LockIndex, pair.Session = existing.CreateIndex, existing.LockIndex, existing.Session
+	}
+	s.kv[key] = pair
+}
+
+func (s *Server) deleteLocked(key string, recurse bool) bool {
+	deleted := false
+	for stored := range s.kv {
+		if stored == key || (recurse && strings.HasPrefix(stored, key)) {
+			delete(s.kv, stored)
+			deleted = true
+		}
+	}
+	return deleted
+}
+
+// casMatchesLocked implements Consul's check-and-set: index 0 means "only if absent"
+func (s *Server) casMatchesLocked(key string, index uint64) bool {
+	return s.modifyIndexLocked(key) == index
+}
+
+func (s *Server) modifyIndexLocked(key string) uint64 {
+	if pair, ok := s.kv[key]; ok {
+		return pair.ModifyIndex
+	}
+	return 0
+}
+
+func (s *Server) sortedKeysLocked() []string {
+	keys := make([]string, 0, len(s.kv))
+	for key := range s.kv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) notifyLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) writeIndexLocked(w http.ResponseWriter, index uint64) {
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	w.Header().Set("X-Consul-KnownLeader", "true")
+	w.Header().Set("X-Consul-LastContact", "0")
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func hasTags(tags []string, required []string) bool {
+	for _, tag := range required {
+		found := false
+		for _, t := range tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func checkType(check *api.AgentServiceCheck) string {
+	switch {
+	case check.TTL != "":
+		return "ttl"
+	case check.HTTP != "":
+		return "http"
+	case check.TCP != "":
+		return "tcp"
+	case check.GRPC != "":
+		return "grpc"
+	}
+	return ""
+}
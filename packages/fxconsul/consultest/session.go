@@ -0,0 +1,250 @@
+package consultest
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// defaultLockDelay is the lock-delay of sessions created without one, like the agent's
+const defaultLockDelay = 15 * time.Second
+
+// session is an emulated Consul session; it is invalidated when its TTL elapses without a renewal
+type session struct {
+	entry   api.SessionEntry
+	ttl     time.Duration
+	expires time.Time
+	timer   *time.Timer
+}
+
+// Sessions returns the IDs of the live sessions in no particular order
+func (s *Server) Sessions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.sessions))
+	for id := range s.sessions {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// InvalidateSession destroys a session as if its TTL had expired, releasing (or deleting, with the "delete"
+// behavior) the keys it holds. Returns false when the session does not exist.
+func (s *Server) InvalidateSession(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.invalidateSessionLocked(id)
+}
+
+// LockHolder returns the session holding a key, "" when the key is not locked
+func (s *Server) LockHolder(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pair, ok := s.kv[key]; ok {
+		return pair.Session
+	}
+	return ""
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+
+// handleSession serves create, renew, destroy, info and list
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/session/")
+	operation, id, _ := strings.Cut(path, "/")
+	switch operation {
+	case "create":
+		s.handleSessionCreate(w, r)
+	case "renew":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		current, ok := s.sessions[id]
+		if !ok {
+			http.Error(w, "consultest: session "+id+" not found", http.StatusNotFound)
+			return
+		}
+		if current.timer != nil {
+			current.expires = time.Now().Add(current.ttl)
+			current.timer.Reset(current.ttl)
+		}
+		writeJSON(w, http.StatusOK, []api.SessionEntry{current.entry})
+	case "destroy":
+		s.mu.Lock()
+		s.invalidateSessionLocked(id)
+		s.writeIndexLocked(w, s.index)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, true)
+	case "info":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.writeIndexLocked(w, s.index)
+		entries := make([]api.SessionEntry, 0, 1)
+		if current, ok := s.sessions[id]; ok {
+			entries = append(entries, current.entry)
+		}
+		writeJSON(w, http.StatusOK, entries)
+	case "list":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.writeIndexLocked(w, s.index)
+		entries := make([]api.SessionEntry, 0, len(s.sessions))
+		for _, current := range s.sessions {
+			entries = append(entries, current.entry)
+		}
+		writeJSON(w, http.StatusOK, entries)
+	default:
+		http.Error(w, "consultest: unsupported session operation "+operation, http.StatusNotFound)
+	}
+}
+
+func (s *Server) handleSessionCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "consultest: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// The client sends LockDelay as a duration string such as "15000ms"
+	var request struct {
+		Name      string
+		TTL       string
+		LockDelay string
+		Behavior  string
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	lockDelay := defaultLockDelay
+	if request.LockDelay != "" {
+		parsed, err := time.ParseDuration(request.LockDelay)
+		if err != nil {
+			http.Error(w, "consultest: invalid LockDelay: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		lockDelay = parsed
+	}
+	var ttl time.Duration
+	if request.TTL != "" {
+		parsed, err := time.ParseDuration(request.TTL)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "consultest: invalid TTL "+request.TTL, http.StatusBadRequest)
+			return
+		}
+		ttl = parsed
+	}
+	if request.Behavior == "" {
+		request.Behavior = api.SessionBehaviorRelease
+	}
+
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index++
+	created := &session{
+		entry: api.SessionEntry{
+			CreateIndex: s.index,
+			ID:          fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]),
+			Name:        request.Name,
+			Node:        "consultest",
+			LockDelay:   lockDelay,
+			Behavior:    request.Behavior,
+			TTL:         request.TTL,
+		},
+		ttl: ttl,
+	}
+	if ttl > 0 {
+		created.expires = time.Now().Add(ttl)
+		created.timer = time.AfterFunc(ttl, func() { s.expireSession(created) })
+	}
+	s.sessions[created.entry.ID] = created
+	s.writeIndexLocked(w, s.index)
+	writeJSON(w, http.StatusOK, map[string]string{"ID": created.entry.ID})
+}
+
+// expireSession invalidates a session whose TTL elapsed, unless it was renewed meanwhile
+func (s *Server) expireSession(expired *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions[expired.entry.ID] == expired && !time.Now().Before(expired.expires) {
+		s.invalidateSessionLocked(expired.entry.ID)
+	}
+}
+
+func (s *Server) invalidateSessionLocked(id string) bool {
+	invalidated, ok := s.sessions[id]
+	if !ok {
+		return false
+	}
+	delete(s.sessions, id)
+	if invalidated.timer != nil {
+		invalidated.timer.Stop()
+	}
+	s.index++
+	for key, pair := range s.kv {
+		if pair.Session != id {
+			continue
+		}
+		if invalidated.entry.Behavior == api.SessionBehaviorDelete {
+			delete(s.kv, key)
+			continue
+		}
+		released := *pair
+		released.Session = ""
+		released.ModifyIndex = s.index
+		s.kv[key] = &released
+		if invalidated.entry.LockDelay > 0 {
+			s.lockDelays[key] = time.Now().Add(invalidated.entry.LockDelay)
+		}
+	}
+	s.notifyLocked()
+	return true
+}
+
+// acquireLocked implements ?acquire=: the key is written and locked unless another session holds it,
+// the session does not exist or a lock-delay is in effect
+func (s *Server) acquireLocked(key string, value []byte, flags uint64, id string) bool {
+	if _, ok := s.sessions[id]; !ok {
+		return false
+	}
+	existing, exists := s.kv[key]
+	if exists && existing.Session != "" && existing.Session != id {
+		return false
+	}
+	if until, ok := s.lockDelays[key]; ok {
+		if time.Now().Before(until) {
+			return false
+		}
+		delete(s.lockDelays, key)
+	}
+	lockIndex := uint64(0)
+	if exists {
+		lockIndex = existing.LockIndex
+	}
+	if !exists || existing.Session != id {
+		lockIndex++
+	}
+	s.putLocked(key, value, flags)
+	s.kv[key].Session = id
+	s.kv[key].LockIndex = lockIndex
+	return true
+}
+
+// releaseLocked implements ?release=: the key is written and unlocked when the session holds it
+func (s *Server) releaseLocked(key string, value []byte, flags uint64, id string) bool {
+	existing, exists := s.kv[key]
+	if !exists || existing.Session != id {
+		return false
+	}
+	s.putLocked(key, value, flags)
+	s.kv[key].Session = ""
+	return true
+}
@@ -0,0 +1,361 @@
+package fxconsul
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// ServiceInstance is a healthy instance of a service resolved from the Consul catalog
+type ServiceInstance struct {
+	ID         string            `json:"id"`
+	Node       string            `json:"node"`
+	Service    string            `json:"service"`
+	Address    string            `json:"address"`
+	Port       int               `json:"port"`
+	Tags       []string          `json:"tags"`
+	Meta       map[string]string `json:"meta"`
+	Datacenter string            `json:"datacenter"`
+}
+
+// HostPort returns the instance address as host:port
+func (i ServiceInstance) HostPort() string {
+	return net.JoinHostPort(i.Address, strconv.Itoa(i.Port))
+}
+
+// DiscoveryQuery selects the instances of a service
+type DiscoveryQuery struct {
+	Service    string
+	Tags       []string // Instances must carry every tag
+	Datacenter string   // Defaults to the agent datacenter
+}
+
+// ResolveService returns the instances of a service passing all their health checks
+func (c *ConsulClient) ResolveService(ctx context.Context, query DiscoveryQuery) ([]ServiceInstance, error) {
+	instances, _, err := c.queryService(ctx, query, 0)
+	return instances, err
+}
+
+// ServiceWatcher keeps a local list of healthy instances up to date using blocking queries
+type ServiceWatcher struct {
+	client    *ConsulClient
+	query     DiscoveryQuery
+	instances atomic.Pointer[[]ServiceInstance]
+	cancel    context.CancelFunc
+	done      chan struct{}
+	unclose   func()
+}
+
+// WatchService resolves the service and keeps watching it until ctx is cancelled, Stop is called or the client is closed
+func (c *ConsulClient) WatchService(ctx context.Context, query DiscoveryQuery) (*ServiceWatcher, error) {
+	instances, index, err := c.queryService(ctx, query, 0)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	watcher := &ServiceWatcher{client: c, query: query, cancel: cancel, done: make(chan struct{})}
+	watcher.instances.Store(&instances)
+	go watcher.watchLoop(ctx, index)
+	watcher.unclose = c.onClose(watcher.Stop)
+	return watcher, nil
+}
+
+// Instances returns the current healthy instances
+func (w *ServiceWatcher) Instances() []ServiceInstance {
+	return *w.instances.Load()
+}
+
+// Stop ends the watch and waits for the background query to exit
+func (w *ServiceWatcher) Stop() {
+	w.cancel()
+	<-w.done
+	w.unclose()
+}
+
+func (w *ServiceWatcher) watchLoop(ctx context.Context, index uint64) {
+	defer close(w.done)
+	retryInterval := 5 * time.Second
+	for {
+		instances, lastIndex, err := w.client.queryService(ctx, w.query, index)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			w.client.logger.Printf("Warning: Error watching service %s: %v", w.query.Service, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryInterval):
+				continue
+			}
+		}
+		if lastIndex < index {
+			lastIndex = 0 // Index went backwards, start over
+		}
+		if lastIndex != index {
+			w.instances.Store(&instances)
+		}
+		index = lastIndex
+	}
+}
+
+func (c *ConsulClient) queryService(ctx context.Context, query DiscoveryQuery, waitIndex uint64) ([]ServiceInstance, uint64, error) {
+	if c.client == nil {
+		return nil, 0, fmt.Errorf("consul client is not configured")
+	}
+	opts := (&api.QueryOptions{
+		Datacenter: query.Datacenter,
+		WaitIndex:  waitIndex,
+		WaitTime:   30 * time.Second,
+	}).WithContext(ctx)
+	entries, meta, err := c.client.Health().ServiceMultipleTags(query.Service, query.Tags, true, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	instances := make([]ServiceInstance, 0, len(entries))
+	for _, entry := range entries {
+		address := entry.Service.Address
+		if address == "" {
+			address = entry.Node.Address
+		}
+		instances = append(instances, ServiceInstance{
+			ID:         entry.Service.ID,
+			Node:       entry.Node.Node,
+			Service:    entry.Service.Service,
+			Address:    address,
+			Port:       entry.Service.Port,
+			Tags:       entry.Service.Tags,
+			Meta:       entry.Service.Meta,
+			Datacenter: entry.Node.Datacenter,
+		})
+	}
+	return instances, meta.LastIndex, nil
+}
+
+// BalanceStrategy selects how the transport spreads requests across instances
+type BalanceStrategy string
+
+const (
+	RoundRobin   BalanceStrategy = "round-robin"
+	Random       BalanceStrategy = "random"
+	LeastPending BalanceStrategy = "least-pending"
+)
+
+// LoadBalancedTransport is an http.RoundTripper resolving the request host as a Consul service name,
+// so that http.Client{Transport: t}.Get("http://billing-service/invoices") reaches a healthy instance.
+// Instances failing MaxFailures consecutive requests (transport errors or 5xx) are ejected for EjectionTime.
+type LoadBalancedTransport struct {
+	Base         http.RoundTripper
+	Strategy     BalanceStrategy
+	Tags         []string
+	Datacenter   string
+	MaxFailures  int
+	EjectionTime time.Duration
+
+	client   *ConsulClient
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+	watchers map[string]*serviceWatch
+	states   map[string]map[string]*instanceState // Passive health per service, keyed by node and service ID
+	counter  uint64
+	unclose  func()
+}
+
+// serviceWatch is the watcher of a service, ready once its first resolution completed
+type serviceWatch struct {
+	ready   chan struct{}
+	watcher *ServiceWatcher
+	err     error
+}
+
+type instanceState struct {
+	pending      int64
+	failures     int
+	ejectedUntil time.Time
+}
+
+// NewLoadBalancedTransport creates a balancing transport on top of http.DefaultTransport
+func (c *ConsulClient) NewLoadBalancedTransport(strategy BalanceStrategy) *LoadBalancedTransport {
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &LoadBalancedTransport{
+		Base:         http.DefaultTransport,
+		Strategy:     strategy,
+		MaxFailures:  3,
+		EjectionTime: 30 * time.Second,
+		client:       c,
+		ctx:          ctx,
+		cancel:       cancel,
+		watchers:     make(map[string]*serviceWatch),
+		states:       make(map[string]map[string]*instanceState),
+	}
+	t.unclose = c.onClose(t.Close)
+	return t
+}
+
+// RoundTrip sends the request to an instance of the service named by the request host
+func (t *LoadBalancedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	service := req.URL.Hostname()
+	instance, state, err := t.pick(req.Context(), service)
+	if err != nil {
+		return nil, err
+	}
+
+	outgoing := req.Clone(req.Context())
+	outgoing.URL.Host = instance.HostPort()
+	outgoing.Host = req.Host
+
+	atomic.AddInt64(&state.pending, 1)
+	resp, err := t.Base.RoundTrip(outgoing)
+	atomic.AddInt64(&state.pending, -1)
+
+	t.record(instance, state, err != nil || resp.StatusCode >= http.StatusInternalServerError)
+	return resp, err
+}
+
+// Close stops every service watch of the transport
+func (t *LoadBalancedTransport) Close() {
+	t.unclose()
+	t.cancel()
+	t.mu.Lock()
+	watches := t.watchers
+	t.watchers = make(map[string]*serviceWatch)
+	t.mu.Unlock()
+	for _, watch := range watches {
+		<-watch.ready
+		if watch.watcher != nil {
+			watch.watcher.Stop()
+		}
+	}
+}
+
+// watcher returns the watcher of a service, starting it on first use. The first resolution runs outside
+// t.mu and is shared by the concurrent requests to the service; a failed one is retried by the next request.
+func (t *LoadBalancedTransport) watcher(ctx context.Context, service string) (*ServiceWatcher, error) {
+	t.mu.Lock()
+	watch, started := t.watchers[service]
+	if !started {
+		watch = &serviceWatch{ready: make(chan struct{})}
+		t.watchers[service] = watch
+	}
+	t.mu.Unlock()
+
+	if !started {
+		watch.watcher, watch.err = t.client.WatchService(t.ctx, DiscoveryQuery{Service: service, Tags: t.Tags, Datacenter: t.Datacenter})
+		if watch.err != nil {
+			t.mu.Lock()
+			if t.watchers[service] == watch {
+				delete(t.watchers, service)
+			}
+			t.mu.Unlock()
+		}
+		close(watch.ready)
+	}
+	select {
+	case <-watch.ready:
+		return watch.watcher, watch.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (t *LoadBalancedTransport) pick(ctx context.Context, service string) (ServiceInstance, *instanceState, error) {
+	watcher, err := t.watcher(ctx, service)
+	if err != nil {
+		return ServiceInstance{}, nil, fmt.Errorf("resolve service %s: %w", service, err)
+	}
+	instances := watcher.Instances()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	states := t.serviceStates(service, instances)
+	now := time.Now()
+	candidates := make([]ServiceInstance, 0, len(instances))
+	for _, instance := range instances {
+		if now.After(states[instance.stateKey()].ejectedUntil) {
+			candidates = append(candidates, instance)
+		}
+	}
+	if len(candidates) == 0 {
+		// Every instance is ejected: fall back to all of them rather than failing outright
+		candidates = instances
+	}
+	if len(candidates) == 0 {
+		return ServiceInstance{}, nil, fmt.Errorf("no healthy instances of service %s", service)
+	}
+
+	var chosen ServiceInstance
+	switch t.Strategy {
+	case Random:
+		chosen = candidates[rand.IntN(len(candidates))]
+	case LeastPending:
+		chosen = candidates[0]
+		least := atomic.LoadInt64(&states[chosen.stateKey()].pending)
+		for _, candidate := range candidates[1:] {
+			if pending := atomic.LoadInt64(&states[candidate.stateKey()].pending); pending < least {
+				chosen, least = candidate, pending
+			}
+		}
+	default:
+		t.counter++
+		chosen = candidates[t.counter%uint64(len(candidates))]
+	}
+	return chosen, states[chosen.stateKey()], nil
+}
+
+// serviceStates returns the passive health states of the current instances of a service, creating the
+// missing ones and dropping those of instances that left the catalog; callers hold t.mu
+func (t *LoadBalancedTransport) serviceStates(service string, instances []ServiceInstance) map[string]*instanceState {
+	previous := t.states[service]
+	if len(previous) == len(instances) {
+		current := true
+		for _, instance := range instances {
+			if _, ok := previous[instance.stateKey()]; !ok {
+				current = false
+				break
+			}
+		}
+		if current {
+			return previous
+		}
+	}
+	states := make(map[string]*instanceState, len(instances))
+	for _, instance := range instances {
+		key := instance.stateKey()
+		if state, ok := previous[key]; ok {
+			states[key] = state
+		} else {
+			states[key] = &instanceState{}
+		}
+	}
+	t.states[service] = states
+	return states
+}
+
+// stateKey identifies an instance across nodes: service IDs are only unique within a node
+func (i ServiceInstance) stateKey() string {
+	return i.Node + "/" + i.ID
+}
+
+func (t *LoadBalancedTransport) record(instance ServiceInstance, state *instanceState, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !failed {
+		state.failures = 0
+		return
+	}
+	state.failures++
+	if t.MaxFailures > 0 && state.failures >= t.MaxFailures {
+		state.failures = 0
+		state.ejectedUntil = time.Now().Add(t.EjectionTime)
+		t.client.logger.Printf("Warning: Ejected instance %s of %s for %s", instance.ID, instance.Service, t.EjectionTime)
+	}
+}
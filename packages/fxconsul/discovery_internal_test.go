@@ -0,0 +1,23 @@
+package fxconsul
+
+import "testing"
+
+func TestServiceStatesAreKeyedByNodeAndPruned(t *testing.T) {
+	transport := &LoadBalancedTransport{states: make(map[string]map[string]*instanceState)}
+	a := ServiceInstance{ID: "billing", Node: "node-a"}
+	b := ServiceInstance{ID: "billing", Node: "node-b"}
+
+	states := transport.serviceStates("billing", []ServiceInstance{a, b})
+	if len(states) != 2 || states[a.stateKey()] == states[b.stateKey()] {
+		t.Fatalf("instances with the same ID on two nodes share a state: %v", states)
+	}
+	states[a.stateKey()].failures = 2
+	if again := transport.serviceStates("billing", []ServiceInstance{a, b}); again[a.stateKey()].failures != 2 {
+		t.Error("the state of a remaining instance was reset")
+	}
+
+	states = transport.serviceStates("billing", []ServiceInstance{b})
+	if _, kept := states[a.stateKey()]; kept || len(transport.states["billing"]) != 1 {
+		t.Errorf("the state of an instance that left the catalog was kept: %v", transport.states)
+	}
+}
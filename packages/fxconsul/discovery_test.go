@@ -0,0 +1,96 @@
+package fxconsul_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/tacjlee/common-sdk/packages/fxconsul"
+	"github.com/tacjlee/common-sdk/packages/fxconsul/consultest"
+)
+
+// registerBackend starts an HTTP server answering with its name and registers it as an instance of billing
+func registerBackend(t *testing.T, client *fxconsul.ConsulClient, name string, status int) *fxconsul.ServiceRegistration {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, name)
+	}))
+	t.Cleanup(backend.Close)
+	host, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	registration, err := client.RegisterService(context.Background(), fxconsul.ServiceDefinition{
+		ID: name, Name: "billing", Address: host, Port: portNumber,
+	})
+	if err != nil {
+		t.Fatalf("RegisterService: %v", err)
+	}
+	return registration
+}
+
+func get(t *testing.T, client *http.Client) (string, int) {
+	t.Helper()
+	resp, err := client.Get("http://billing/invoices")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body), resp.StatusCode
+}
+
+func TestLoadBalancedTransportFollowsTheCatalog(t *testing.T) {
+	server := consultest.NewServer(t)
+	client := newClient(t, server)
+	registerBackend(t, client, "billing-1", http.StatusOK)
+	second := registerBackend(t, client, "billing-2", http.StatusOK)
+	transport := client.NewLoadBalancedTransport(fxconsul.RoundRobin)
+	defer transport.Close()
+	httpClient := &http.Client{Transport: transport}
+
+	served := make(map[string]int)
+	for range 4 {
+		name, _ := get(t, httpClient)
+		served[name]++
+	}
+	if served["billing-1"] != 2 || served["billing-2"] != 2 {
+		t.Errorf("round robin served %v, want 2 requests per instance", served)
+	}
+
+	if err := second.Deregister(); err != nil {
+		t.Fatalf("Deregister: %v", err)
+	}
+	eventually(t, func() bool {
+		name, _ := get(t, httpClient)
+		return name == "billing-1"
+	}, "the deregistered instance is still served")
+	for range 3 {
+		if name, _ := get(t, httpClient); name != "billing-1" {
+			t.Errorf("request served by %s after it left the catalog", name)
+		}
+	}
+}
+
+func TestLoadBalancedTransportEjectsFailingInstances(t *testing.T) {
+	server := consultest.NewServer(t)
+	client := newClient(t, server)
+	registerBackend(t, client, "billing-1", http.StatusOK)
+	registerBackend(t, client, "billing-2", http.StatusBadGateway)
+	transport := client.NewLoadBalancedTransport(fxconsul.RoundRobin)
+	transport.MaxFailures = 1
+	defer transport.Close()
+	httpClient := &http.Client{Transport: transport}
+
+	for range 2 {
+		get(t, httpClient)
+	}
+	for range 4 {
+		if name, status := get(t, httpClient); name != "billing-1" || status != http.StatusOK {
+			t.Errorf("request served by %s with %d, want the healthy billing-1", name, status)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package fxconsul
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"gopkg.in/yaml.v3"
+)
+
+// maxTxnOps is the maximum number of operations Consul accepts in a single transaction
+const maxTxnOps = 64
+
+// SettingFormat is the document format used by ExportSettings and ImportSettings
+type SettingFormat string
+
+const (
+	FormatJSON SettingFormat = "json"
+	FormatYAML SettingFormat = "yaml"
+)
+
+// SettingOperation is a single write of an atomic ApplySettings transaction
+type SettingOperation struct {
+	Key         string
+	Value       string
+	Delete      bool
+	ModifyIndex uint64 // When > 0 the operation only succeeds if the key is still at this index
+}
+
+// SetSetting writes a key under basePath
+func (c *ConsulClient) SetSetting(key string, value string) error {
+	if err := c.requireConsul(); err != nil {
+		return err
+	}
+	if _, err := c.client.KV().Put(&api.KVPair{Key: c.consulKey(key), Value: []byte(value)}, nil); err != nil {
+		return err
+	}
+	c.invalidateKeys([]string{key})
+	return nil
+}
+
+// DeleteSetting removes a key under basePath
+func (c *ConsulClient) DeleteSetting(key string) error {
+	if err := c.requireConsul(); err != nil {
+		return err
+	}
+	if _, err := c.client.KV().Delete(c.consulKey(key), nil); err != nil {
+		return err
+	}
+	c.invalidateKeys([]string{key})
+	return nil
+}
+
+// GetSettingWithIndex reads a key directly from Consul together with its ModifyIndex, for use with CompareAndSet
+func (c *ConsulClient) GetSettingWithIndex(key string) (value string, modifyIndex uint64, found bool, err error) {
+	if err := c.requireConsul(); err != nil {
+		return "", 0, false, err
+	}
+	pair, _, err := c.client.KV().Get(c.consulKey(key), nil)
+	if err != nil || pair == nil {
+		return "", 0, false, err
+	}
+	return string(pair.Value), pair.ModifyIndex, true, nil
+}
+
+// CompareAndSet writes the key only if its ModifyIndex still equals modifyIndex.
+// A modifyIndex of 0 creates the key only if it does not exist. Returns false when another writer won.
+func (c *ConsulClient) CompareAndSet(key string, value string, modifyIndex uint64) (bool, error) {
+	if err := c.requireConsul(); err != nil {
+		return false, err
+	}
+	ok, _, err := c.client.KV().CAS(&api.KVPair{Key: c.consulKey(key), Value: []byte(value), ModifyIndex: modifyIndex}, nil)
+	if err != nil {
+		return false, err
+	}
+	c.invalidateKeys([]string{key})
+	return ok, nil
+}
+
+// ApplySettings applies up to 64 operations atomically in a Consul KV transaction
+func (c *ConsulClient) ApplySettings(operations []SettingOperation) error {
+	if err := c.requireConsul(); err != nil {
+		return err
+	}
+	if len(operations) > maxTxnOps {
+		return fmt.Errorf("a transaction supports at most %d operations, got %d", maxTxnOps, len(operations))
+	}
+	txn := make(api.TxnOps, 0, len(operations))
+	keys := make([]string, 0, len(operations))
+	for _, op := range operations {
+		kv := &api.KVTxnOp{Key: c.consulKey(op.Key), Index: op.ModifyIndex}
+		switch {
+		case op.Delete && op.ModifyIndex > 0:
+			kv.Verb = api.KVDeleteCAS
+		case op.Delete:
+			kv.Verb = api.KVDelete
+		case op.ModifyIndex > 0:
+			kv.Verb = api.KVCAS
+			kv.Value = []byte(op.Value)
+		default:
+			kv.Verb = api.KVSet
+			kv.Value = []byte(op.Value)
+		}
+		txn = append(txn, &api.TxnOp{KV: kv})
+		keys = append(keys, op.Key)
+	}
+	ok, response, _, err := c.client.Txn().Txn(txn, nil)
+	if err != nil {
+		return err
+	}
+	c.invalidateKeys(keys)
+	if !ok {
+		messages := make([]string, 0, len(response.Errors))
+		for _, txnErr := range response.Errors {
+			messages = append(messages, fmt.Sprintf("op %d: %s", txnErr.OpIndex, txnErr.What))
+		}
+		return fmt.Errorf("transaction rolled back: %s", strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+// ExportSettings returns every key under prefix (relative to basePath, "" for all) as a flat document
+// whose keys are relative to the prefix, so that it can be imported under another prefix
+func (c *ConsulClient) ExportSettings(prefix string, format SettingFormat) ([]byte, error) {
+	if err := c.requireConsul(); err != nil {
+		return nil, err
+	}
+	if prefix != "" {
+		prefix = strings.TrimSuffix(prefix, "/") + "/"
+	}
+	pairs, _, err := c.client.KV().List(c.consulKey(prefix), nil)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(pairs))
+	for key, entry := range c.snapshotFromPairs(pairs) {
+		values[strings.TrimPrefix(key, prefix)] = entry.value
+	}
+	switch format {
+	case FormatYAML:
+		return yaml.Marshal(values)
+	default:
+		return json.MarshalIndent(values, "", "  ")
+	}
+}
+
+// ImportSettings writes the keys of a flat or nested document under prefix.
+// Each batch of 64 keys is applied atomically; a failure stops the import at that batch.
+func (c *ConsulClient) ImportSettings(prefix string, data []byte, format SettingFormat) error {
+	var document map[string]any
+	var err error
+	switch format {
+	case FormatYAML:
+		err = yaml.Unmarshal(data, &document)
+	default:
+		err = json.Unmarshal(data, &document)
+	}
+	if err != nil {
+		return err
+	}
+	values := make(map[string]string)
+	flattenDocument("", document, values)
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if prefix != "" {
+		prefix = strings.TrimSuffix(prefix, "/") + "/"
+	}
+	for start := 0; start < len(keys); start += maxTxnOps {
+		end := min(start+maxTxnOps, len(keys))
+		operations := make([]SettingOperation, 0, end-start)
+		for _, key := range keys[start:end] {
+			operations = append(operations, SettingOperation{Key: prefix + key, Value: values[key]})
+		}
+		if err := c.ApplySettings(operations); err != nil {
+			return fmt.Errorf("import keys %d-%d: %w", start, end-1, err)
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+func (c *ConsulClient) consulKey(key string) string {
+	return c.basePath + "/" + key
+}
+
+func (c *ConsulClient) requireConsul() error {
+	if c.client == nil || !c.IsAvailable() {
+		return fmt.Errorf("consul is not available")
+	}
+	return nil
+}
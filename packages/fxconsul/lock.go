@@ -0,0 +1,229 @@
+package fxconsul
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// LockOptions configures a distributed lock
+type LockOptions struct {
+	Key            string        // Full KV path of the lock, e.g. "locks/billing/invoice-job"
+	Value          string        // Stored with the lock, defaults to the hostname of the holder
+	SessionTTL     time.Duration // Session TTL renewed while the lock is held, defaults to 15s
+	LockDelay      time.Duration // Time Consul blocks re-acquisition after the holder's session is invalidated, defaults to 15s
+	MonitorRetries int           // Transient errors tolerated while monitoring the lock, defaults to 3
+}
+
+// DistributedLock is a held Consul lock backed by a session that is renewed in the background
+type DistributedLock struct {
+	key  string
+	lock *api.Lock
+	lost <-chan struct{}
+}
+
+// Lock blocks until the lock on key is acquired or ctx is cancelled
+func (c *ConsulClient) Lock(ctx context.Context, key string) (*DistributedLock, error) {
+	return c.LockWithOptions(ctx, LockOptions{Key: key})
+}
+
+// LockWithOptions blocks until the lock is acquired or ctx is cancelled.
+// Consul's lock-delay is honoured: after a holder's session is invalidated the lock becomes acquirable only once it expires.
+func (c *ConsulClient) LockWithOptions(ctx context.Context, options LockOptions) (*DistributedLock, error) {
+	if c.client == nil {
+		return nil, fmt.Errorf("consul client is not configured")
+	}
+	if options.Key == "" {
+		return nil, fmt.Errorf("lock key is required")
+	}
+	if options.SessionTTL <= 0 {
+		options.SessionTTL = 15 * time.Second
+	}
+	if options.LockDelay <= 0 {
+		options.LockDelay = 15 * time.Second
+	}
+	if options.MonitorRetries <= 0 {
+		options.MonitorRetries = 3
+	}
+	if options.Value == "" {
+		options.Value, _ = os.Hostname()
+	}
+
+	lock, err := c.client.LockOpts(&api.LockOptions{
+		Key:            options.Key,
+		Value:          []byte(options.Value),
+		SessionTTL:     options.SessionTTL.String(),
+		SessionName:    "fxconsul-lock:" + options.Key,
+		LockDelay:      options.LockDelay,
+		MonitorRetries: options.MonitorRetries,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stopCh := make(chan struct{})
+	acquired := make(chan struct{})
+	defer close(acquired)
+	go func() {
+		select {
+		case <-ctx.Done():
+			close(stopCh)
+		case <-acquired:
+		}
+	}()
+
+	lost, err := lock.Lock(stopCh)
+	if err != nil {
+		return nil, err
+	}
+	if lost == nil {
+		return nil, ctx.Err()
+	}
+	return &DistributedLock{key: options.Key, lock: lock, lost: lost}, nil
+}
+
+// Key returns the KV path of the lock
+func (l *DistributedLock) Key() string {
+	return l.key
+}
+
+// Lost is closed when the lock is lost, e.g. the session was invalidated or Consul became unreachable
+func (l *DistributedLock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Unlock releases the lock and destroys its session
+func (l *DistributedLock) Unlock() error {
+	if err := l.lock.Unlock(); err != nil && err != api.ErrLockNotHeld {
+		return err
+	}
+	return nil
+}
+
+// LeaderCallbacks are invoked on leadership transitions
+type LeaderCallbacks struct {
+	// OnElected runs on the election goroutine when leadership is acquired and must return promptly:
+	// long-running work should be started in its own goroutine and stop when ctx is cancelled on demotion
+	OnElected func(ctx context.Context)
+	// OnDemoted runs after leadership is lost or given up, always after the matching OnElected returned
+	OnDemoted func()
+}
+
+// LeaderElection campaigns for a lock until its context is cancelled
+type LeaderElection struct {
+	client    *ConsulClient
+	options   LockOptions
+	callbacks LeaderCallbacks
+	leader    atomic.Bool
+	cancel    context.CancelFunc
+	done      chan struct{}
+	stopOnce  sync.Once
+	unclose   func()
+}
+
+// RunLeaderElection campaigns for leadership in the background. When the lock is lost (including when
+// Consul goes away) OnDemoted is called and the election resumes once Consul is reachable again.
+func (c *ConsulClient) RunLeaderElection(ctx context.Context, options LockOptions, callbacks LeaderCallbacks) *LeaderElection {
+	ctx, cancel := context.WithCancel(ctx)
+	election := &LeaderElection{
+		client:    c,
+		options:   options,
+		callbacks: callbacks,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	go election.campaign(ctx)
+	election.unclose = c.onClose(election.Stop)
+	return election
+}
+
+// IsLeader reports whether this instance currently holds leadership
+func (e *LeaderElection) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Stop resigns leadership and ends the election
+func (e *LeaderElection) Stop() {
+	e.stopOnce.Do(func() {
+		e.cancel()
+		<-e.done
+		e.unclose()
+	})
+}
+
+func (e *LeaderElection) campaign(ctx context.Context) {
+	defer close(e.done)
+	c := e.client
+
+	for ctx.Err() == nil {
+		if !c.IsAvailable() {
+			c.tryReconnect()
+			if !c.IsAvailable() {
+				if !sleepContext(ctx, c.retryDelay()) {
+					return
+				}
+				continue
+			}
+		}
+
+		lock, err := c.LockWithOptions(ctx, e.options)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.logger.Printf("Warning: Leader election on %s failed: %v", e.options.Key, err)
+			c.recordFailure(err)
+			if !sleepContext(ctx, c.retryDelay()) {
+				return
+			}
+			continue
+		}
+
+		e.lead(ctx, lock)
+	}
+}
+
+// lead holds leadership until the lock is lost or ctx is cancelled
+func (e *LeaderElection) lead(ctx context.Context, lock *DistributedLock) {
+	c := e.client
+	leaderCtx, demote := context.WithCancel(ctx)
+	e.leader.Store(true)
+	c.logger.Printf("Elected leader for %s", lock.Key())
+	if e.callbacks.OnElected != nil {
+		e.callbacks.OnElected(leaderCtx)
+	}
+
+	select {
+	case <-lock.Lost():
+		c.logger.Printf("Warning: Lost leadership for %s", lock.Key())
+		// Stop the lock monitor and destroy the session before campaigning again
+		if err := lock.Unlock(); err != nil {
+			c.logger.Printf("Warning: Failed to clean up the lost lock %s: %v", lock.Key(), err)
+		}
+	case <-ctx.Done():
+		if err := lock.Unlock(); err != nil {
+			c.logger.Printf("Warning: Failed to release leadership for %s: %v", lock.Key(), err)
+		}
+	}
+
+	demote()
+	e.leader.Store(false)
+	if e.callbacks.OnDemoted != nil {
+		e.callbacks.OnDemoted()
+	}
+}
+
+// sleepContext waits for d and reports false when ctx was cancelled first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
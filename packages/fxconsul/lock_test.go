@@ -0,0 +1,174 @@
+package fxconsul_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tacjlee/common-sdk/packages/fxconsul"
+	"github.com/tacjlee/common-sdk/packages/fxconsul/consultest"
+)
+
+const lockKey = "locks/billing/invoice-job"
+
+func lockOptions() fxconsul.LockOptions {
+	return fxconsul.LockOptions{Key: lockKey, SessionTTL: 10 * time.Second, LockDelay: time.Millisecond}
+}
+
+func TestLockIsExclusive(t *testing.T) {
+	server := consultest.NewServer(t)
+	first := newClient(t, server)
+	second := newClient(t, server)
+
+	lock, err := first.LockWithOptions(context.Background(), lockOptions())
+	if err != nil {
+		t.Fatalf("LockWithOptions: %v", err)
+	}
+	if server.LockHolder(lockKey) == "" {
+		t.Fatal("the lock key is not held by a session")
+	}
+
+	acquired := make(chan *fxconsul.DistributedLock, 1)
+	go func() {
+		lock, err := second.LockWithOptions(context.Background(), lockOptions())
+		if err != nil {
+			t.Errorf("LockWithOptions after unlock: %v", err)
+		}
+		acquired <- lock
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("a second holder acquired the lock")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	select {
+	case next := <-acquired:
+		if next != nil {
+			_ = next.Unlock()
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("the waiting holder did not acquire the released lock")
+	}
+}
+
+func TestLockLostWhenSessionIsInvalidated(t *testing.T) {
+	server := consultest.NewServer(t)
+	client := newClient(t, server)
+	lock, err := client.LockWithOptions(context.Background(), lockOptions())
+	if err != nil {
+		t.Fatalf("LockWithOptions: %v", err)
+	}
+	defer lock.Unlock()
+
+	if !server.InvalidateSession(server.LockHolder(lockKey)) {
+		t.Fatal("the lock session does not exist")
+	}
+	select {
+	case <-lock.Lost():
+	case <-time.After(waitTimeout):
+		t.Fatal("Lost was not closed")
+	}
+}
+
+func TestLockSessionExpiresWithoutRenewal(t *testing.T) {
+	server := consultest.NewServer(t)
+	client := newClient(t, server)
+	options := lockOptions()
+	options.SessionTTL = 200 * time.Millisecond
+	lock, err := client.LockWithOptions(context.Background(), options)
+	if err != nil {
+		t.Fatalf("LockWithOptions: %v", err)
+	}
+	defer lock.Unlock()
+
+	// The session is renewed every TTL/2, so the lock outlives its TTL
+	time.Sleep(500 * time.Millisecond)
+	select {
+	case <-lock.Lost():
+		t.Fatal("the lock was lost although its session was renewed")
+	default:
+	}
+
+	// Renewals fail while the agent is down, and the session expires
+	server.SetAvailable(false)
+	time.Sleep(300 * time.Millisecond)
+	server.SetAvailable(true)
+	select {
+	case <-lock.Lost():
+	case <-time.After(waitTimeout):
+		t.Fatal("Lost was not closed")
+	}
+	if holder := server.LockHolder(lockKey); holder != "" {
+		t.Errorf("the expired session %s still holds the lock", holder)
+	}
+}
+
+func TestLeaderElectionFailsOver(t *testing.T) {
+	server := consultest.NewServer(t)
+	var elected atomic.Int32
+	callbacks := func(id int32) fxconsul.LeaderCallbacks {
+		return fxconsul.LeaderCallbacks{
+			OnElected: func(ctx context.Context) { elected.Store(id) },
+		}
+	}
+	first := newClient(t, server).RunLeaderElection(context.Background(), lockOptions(), callbacks(1))
+	eventually(t, first.IsLeader, "the first candidate was not elected")
+	second := newClient(t, server).RunLeaderElection(context.Background(), lockOptions(), callbacks(2))
+	defer second.Stop()
+	time.Sleep(100 * time.Millisecond)
+	if second.IsLeader() {
+		t.Fatal("both candidates are leaders")
+	}
+
+	first.Stop()
+	if first.IsLeader() {
+		t.Error("the stopped candidate is still leader")
+	}
+	eventually(t, second.IsLeader, "the second candidate did not take over")
+	eventually(t, func() bool { return elected.Load() == 2 }, "OnElected was not called for the second candidate")
+}
+
+func TestLeaderElectionResumesAfterLostSession(t *testing.T) {
+	server := consultest.NewServer(t)
+	demoted := make(chan struct{}, 4)
+	client := newClient(t, server, fxconsul.WithBackoff(10*time.Millisecond, 50*time.Millisecond))
+	election := client.RunLeaderElection(context.Background(), lockOptions(), fxconsul.LeaderCallbacks{
+		OnDemoted: func() { demoted <- struct{}{} },
+	})
+	defer election.Stop()
+	eventually(t, election.IsLeader, "the candidate was not elected")
+
+	session := server.LockHolder(lockKey)
+	server.InvalidateSession(session)
+	select {
+	case <-demoted:
+	case <-time.After(waitTimeout):
+		t.Fatal("OnDemoted was not called")
+	}
+	eventually(t, func() bool {
+		holder := server.LockHolder(lockKey)
+		return election.IsLeader() && holder != "" && holder != session
+	}, "the candidate was not re-elected with a new session")
+}
+
+func TestLeaderCallbacksRunInOrder(t *testing.T) {
+	server := consultest.NewServer(t)
+	transitions := make(chan string, 2)
+	election := newClient(t, server).RunLeaderElection(context.Background(), lockOptions(), fxconsul.LeaderCallbacks{
+		OnElected: func(ctx context.Context) {
+			time.Sleep(100 * time.Millisecond)
+			transitions <- "elected"
+		},
+		OnDemoted: func() { transitions <- "demoted" },
+	})
+	eventually(t, election.IsLeader, "the candidate was not elected")
+	election.Stop()
+
+	if first, second := <-transitions, <-transitions; first != "elected" || second != "demoted" {
+		t.Errorf("callbacks ran as %s, %s; want elected, demoted", first, second)
+	}
+}
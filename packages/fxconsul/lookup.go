@@ -0,0 +1,140 @@
+package fxconsul
+
+import (
+	"context"
+	"time"
+)
+
+// WithRequestTimeout bounds every per-key Consul lookup (defaults to 5s)
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(o *clientOptions) {
+		o.requestTimeout = timeout
+	}
+}
+
+// WithRefreshAhead reloads cached values in the background when a read finds them within window of
+// expiry, so hot keys never miss (defaults to 10s, capped at half the cache TTL; 0 disables)
+func WithRefreshAhead(window time.Duration) Option {
+	return func(o *clientOptions) {
+		o.refreshAhead = window
+	}
+}
+
+// WithStaleOnError serves cache entries expired for up to maxStale when Consul cannot be reached
+// (defaults to 10m; 0 disables)
+func WithStaleOnError(maxStale time.Duration) Option {
+	return func(o *clientOptions) {
+		o.maxStale = maxStale
+	}
+}
+
+// GetSettingContext is GetSetting with a context: a cache miss waits for Consul until ctx is done, then
+// falls back like GetSetting. Concurrent misses of the same key share a single Consul request.
+// An empty defaultValue selects the schema default of the key, if any.
+func (c *ConsulClient) GetSettingContext(ctx context.Context, key string, defaultValue string) string {
+	if value, _, ok := c.sources.lookupContext(ctx, key); ok {
+		return value
+	}
+
+	if defaultValue == "" && c.schema != nil {
+		return c.schema.settings[key].Default
+	}
+	return defaultValue
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+
+// flight is a Consul lookup shared by every caller that missed the same key
+type flight struct {
+	done  chan struct{}
+	value string
+	found bool
+	err   error
+}
+
+// fetch reads a key from Consul, joining the lookup already in flight for it if any.
+// The lookup itself is bounded by the request timeout, not by ctx, so that callers giving up early
+// do not fail the others; its result is cached either way.
+func (c *ConsulClient) fetch(ctx context.Context, key string) (string, bool, error) {
+	call, started := c.startFlight(key)
+	if !started {
+		c.coalesced.Add(1)
+	}
+	select {
+	case <-call.done:
+		return call.value, call.found, call.err
+	case <-ctx.Done():
+		return "", false, ctx.Err()
+	}
+}
+
+// refreshInBackground reloads a key about to expire unless a lookup for it is already in flight
+func (c *ConsulClient) refreshInBackground(key string) {
+	c.flightsMu.Lock()
+	_, inFlight := c.flights[key]
+	c.flightsMu.Unlock()
+	if inFlight || !c.allowRequest() {
+		return
+	}
+	if _, started := c.startFlight(key); started {
+		c.refreshAheads.Add(1)
+	}
+}
+
+// startFlight returns the lookup in flight for the key, starting one when there is none
+func (c *ConsulClient) startFlight(key string) (*flight, bool) {
+	c.flightsMu.Lock()
+	defer c.flightsMu.Unlock()
+	if call, ok := c.flights[key]; ok {
+		return call, false
+	}
+	call := &flight{done: make(chan struct{})}
+	c.flights[key] = call
+	go c.runFlight(key, call)
+	return call, true
+}
+
+// runFlight reads the key and caches the result, unless the cache was invalidated meanwhile: the value
+// read may then predate the change, and caching it would serve it for a whole TTL.
+func (c *ConsulClient) runFlight(key string, call *flight) {
+	c.cacheMu.RLock()
+	generation := c.cacheGen
+	c.cacheMu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.requestTimeout)
+	defer cancel()
+	call.value, call.found, call.err = c.lookupLayers(ctx, key)
+	if call.err != nil {
+		c.logger.Printf("Warning: Failed to read %s from Consul: %v", key, call.err)
+		c.recordFailure(call.err)
+	} else {
+		c.recordSuccess()
+		c.cacheMu.Lock()
+		if c.cacheGen == generation {
+			c.cache[key] = cacheEntry{value: call.value, found: call.found, expiresAt: time.Now().Add(c.cacheTTL)}
+		}
+		c.cacheMu.Unlock()
+	}
+
+	c.flightsMu.Lock()
+	delete(c.flights, key)
+	c.flightsMu.Unlock()
+	close(call.done)
+}
+
+// lookupStale returns an expired cache entry that is still within the stale-on-error window
+func (c *ConsulClient) lookupStale(key string) (string, bool) {
+	if c.maxStale <= 0 {
+		return "", false
+	}
+	c.cacheMu.RLock()
+	entry, ok := c.cache[key]
+	c.cacheMu.RUnlock()
+	if !ok || !entry.found || time.Since(entry.expiresAt) > c.maxStale {
+		return "", false
+	}
+	c.staleServed.Add(1)
+	return entry.value, true
+}
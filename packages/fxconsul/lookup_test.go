@@ -0,0 +1,116 @@
+package fxconsul_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tacjlee/common-sdk/packages/fxconsul"
+	"github.com/tacjlee/common-sdk/packages/fxconsul/consultest"
+)
+
+func TestConcurrentMissesShareOneLookup(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.Set("config/dev/settings/db/host", "db.internal")
+	client := newClient(t, server)
+	server.SetLatency(100 * time.Millisecond)
+
+	const callers = 8
+	var wg sync.WaitGroup
+	values := make([]string, callers)
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			values[i] = client.GetSettingContext(context.Background(), "db/host", "")
+		}()
+	}
+	wg.Wait()
+
+	for i, value := range values {
+		if value != "db.internal" {
+			t.Errorf("caller %d read %q, want db.internal", i, value)
+		}
+	}
+	metrics := client.Metrics()
+	if metrics.CacheMisses != callers || metrics.CoalescedLookups == 0 {
+		t.Errorf("%d misses and %d coalesced lookups, want %d misses sharing lookups", metrics.CacheMisses, metrics.CoalescedLookups, callers)
+	}
+}
+
+func TestGetSettingContextGivesUpWhenContextEnds(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.Set("config/dev/settings/db/host", "db.internal")
+	client := newClient(t, server)
+	server.SetLatency(time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if got := client.GetSettingContext(ctx, "db/host", "localhost"); got != "localhost" {
+		t.Errorf("GetSettingContext = %q, want the default localhost", got)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("GetSettingContext returned after %s, want about 50ms", elapsed)
+	}
+}
+
+func TestStaleValueServedAfterRefreshDuringOutage(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.Set("config/dev/settings/db/host", "db.internal")
+	client := newClient(t, server, fxconsul.WithRequestTimeout(200*time.Millisecond))
+	if got := client.GetSetting("db/host", ""); got != "db.internal" {
+		t.Fatalf("GetSetting(db/host) = %q, want db.internal", got)
+	}
+
+	// RefreshCache expires the entry without dropping it
+	client.RefreshCache()
+	server.SetAvailable(false)
+	if got := client.GetSetting("db/host", "localhost"); got != "db.internal" {
+		t.Errorf("GetSetting(db/host) during the outage = %q, want the stale db.internal", got)
+	}
+	if served := client.Metrics().StaleServed; served != 1 {
+		t.Errorf("%d stale values served, want 1", served)
+	}
+}
+
+func TestStaleValueNotServedPastMaxStale(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.Set("config/dev/settings/db/host", "db.internal")
+	client := newClient(t, server, fxconsul.WithCacheTTL(10*time.Millisecond), fxconsul.WithStaleOnError(10*time.Millisecond))
+	client.GetSetting("db/host", "")
+
+	server.SetAvailable(false)
+	time.Sleep(50 * time.Millisecond)
+	// The key was never watched, so there is no snapshot to fall back to either
+	if got := client.GetSetting("db/host", "localhost"); got != "localhost" {
+		t.Errorf("GetSetting(db/host) = %q, want the default localhost", got)
+	}
+}
+
+func TestInvalidationDuringLookupIsNotOverwritten(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.Set("config/dev/settings/db/host", "db1")
+	client := newClient(t, server)
+	changes := subscribe(client, "db/host")
+	watch(t, server, client)
+
+	// The lookup reads db1, then the watch applies db2 while the lookup's response is still on its way
+	server.SetLatency(300 * time.Millisecond)
+	read := make(chan string)
+	go func() {
+		read <- client.GetSettingContext(context.Background(), "db/host", "")
+	}()
+	time.Sleep(50 * time.Millisecond)
+	server.SetLatency(0)
+	server.Set("config/dev/settings/db/host", "db2")
+	receive(t, changes)
+	if got := <-read; got != "db1" {
+		t.Fatalf("the lookup read %q, want db1", got)
+	}
+
+	if got := client.GetSetting("db/host", ""); got != "db2" {
+		t.Errorf("GetSetting(db/host) = %q after the change, want db2", got)
+	}
+}
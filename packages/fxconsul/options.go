@@ -0,0 +1,214 @@
+package fxconsul
+
+import (
+	"encoding/base64"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Logger is the logging interface used by ConsulClient, satisfied by *log.Logger
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// Option configures a ConsulClient created with NewConsulClient
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	enabled      bool
+	config       *api.Config
+	basePath     string
+	layers       []string
+	environments []string
+	cacheTTL     time.Duration
+	logger       Logger
+
+	snapshotFile string
+	snapshotKey  []byte
+	keyring      *SecretKeyring
+	strict       bool
+	schema       *ConfigSchema
+	auditSinks   []AuditSink
+	auditHashKey []byte
+
+	initialBackoff   time.Duration
+	maxBackoff       time.Duration
+	failureThreshold int
+
+	requestTimeout time.Duration
+	refreshAhead   time.Duration
+	maxStale       time.Duration
+}
+
+func defaultClientOptions() *clientOptions {
+	return &clientOptions{
+		enabled:      true,
+		config:       api.DefaultConfig(),
+		basePath:     "config/dev/settings",
+		environments: ProfileEnvironments,
+		cacheTTL:     60 * time.Second,
+		logger:       log.Default(),
+
+		initialBackoff:   time.Second,
+		maxBackoff:       time.Minute,
+		failureThreshold: 3,
+
+		requestTimeout: 5 * time.Second,
+		refreshAhead:   10 * time.Second,
+		maxStale:       10 * time.Minute,
+	}
+}
+
+// WithEnabled disables all Consul lookups when false; settings then come from the environment only
+func WithEnabled(enabled bool) Option {
+	return func(o *clientOptions) {
+		o.enabled = enabled
+	}
+}
+
+// WithAddress sets the Consul agent address as host:port
+func WithAddress(address string) Option {
+	return func(o *clientOptions) {
+		o.config.Address = address
+	}
+}
+
+// WithScheme sets the URI scheme of the agent ("http" or "https")
+func WithScheme(scheme string) Option {
+	return func(o *clientOptions) {
+		o.config.Scheme = scheme
+	}
+}
+
+// WithTLSFiles configures TLS with a CA bundle and an optional client certificate
+func WithTLSFiles(caFile string, certFile string, keyFile string) Option {
+	return func(o *clientOptions) {
+		o.config.TLSConfig.CAFile = caFile
+		o.config.TLSConfig.CertFile = certFile
+		o.config.TLSConfig.KeyFile = keyFile
+	}
+}
+
+// WithTLSConfig replaces the complete TLS configuration of the Consul client
+func WithTLSConfig(tlsConfig api.TLSConfig) Option {
+	return func(o *clientOptions) {
+		o.config.TLSConfig = tlsConfig
+	}
+}
+
+// WithToken sets the ACL token sent with every request
+func WithToken(token string) Option {
+	return func(o *clientOptions) {
+		o.config.Token = token
+	}
+}
+
+// WithDatacenter sets the datacenter queried by the client
+func WithDatacenter(datacenter string) Option {
+	return func(o *clientOptions) {
+		o.config.Datacenter = datacenter
+	}
+}
+
+// WithNamespace sets the Consul Enterprise namespace
+func WithNamespace(namespace string) Option {
+	return func(o *clientOptions) {
+		o.config.Namespace = namespace
+	}
+}
+
+// WithBasePath sets the KV prefix under which settings are stored
+func WithBasePath(basePath string) Option {
+	return func(o *clientOptions) {
+		o.basePath = basePath
+		o.layers = nil
+	}
+}
+
+// WithCacheTTL sets how long values read from Consul are cached
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(o *clientOptions) {
+		o.cacheTTL = ttl
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to talk to the agent
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(o *clientOptions) {
+		o.config.HttpClient = httpClient
+	}
+}
+
+// WithLogger sets the logger of the client (defaults to the standard logger)
+func WithLogger(logger Logger) Option {
+	return func(o *clientOptions) {
+		o.logger = logger
+	}
+}
+
+// optionsFromEnv maps the CONSUL_* environment variables to options. CONSUL_STRICT maps to WithStrictSettings,
+// which only applies together with WithSchema.
+func optionsFromEnv() []Option {
+	if os.Getenv("CONSUL_ENABLED") == "false" {
+		return append([]Option{
+			WithEnabled(false),
+			WithBasePath(envOrDefault("CONSUL_BASE_PATH", "config/dev/settings")),
+			WithStrictSettings(os.Getenv("CONSUL_STRICT") == "true"),
+		}, secretOptionsFromEnv()...)
+	}
+
+	options := []Option{
+		WithAddress(envOrDefault("CONSUL_HOST", "localhost") + ":" + envOrDefault("CONSUL_PORT", "8500")),
+		WithBasePath(envOrDefault("CONSUL_BASE_PATH", "config/dev/settings")),
+		WithStrictSettings(os.Getenv("CONSUL_STRICT") == "true"),
+	}
+	if ttlSeconds := os.Getenv("CONSUL_CACHE_TTL"); ttlSeconds != "" {
+		if parsed, err := time.ParseDuration(ttlSeconds + "s"); err == nil {
+			options = append(options, WithCacheTTL(parsed))
+		}
+	}
+	if snapshotFile := os.Getenv("CONSUL_SNAPSHOT_FILE"); snapshotFile != "" {
+		options = append(options, WithSnapshotFile(snapshotFile))
+	}
+	if snapshotKey := os.Getenv("CONSUL_SNAPSHOT_KEY"); snapshotKey != "" {
+		if key, err := base64.StdEncoding.DecodeString(snapshotKey); err == nil {
+			options = append(options, WithSnapshotEncryptionKey(key))
+		} else {
+			log.Printf("Warning: CONSUL_SNAPSHOT_KEY is not valid base64: %v", err)
+		}
+	}
+	if service, env := os.Getenv("CONSUL_SERVICE"), os.Getenv("CONSUL_ENV"); service != "" && env != "" {
+		options = append(options, WithProfile(service, env))
+	}
+	options = append(options, secretOptionsFromEnv()...)
+	return options
+}
+
+// secretOptionsFromEnv loads the secret keyring from CONSUL_SECRET_KEYS or CONSUL_SECRET_KEYS_FILE
+func secretOptionsFromEnv() []Option {
+	var keyring *SecretKeyring
+	var err error
+	if spec := os.Getenv("CONSUL_SECRET_KEYS"); spec != "" {
+		keyring, err = ParseSecretKeyring(spec)
+	} else if path := os.Getenv("CONSUL_SECRET_KEYS_FILE"); path != "" {
+		keyring, err = LoadSecretKeyringFile(path)
+	} else {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to load Consul secret keyring: %v", err)
+		return nil
+	}
+	return []Option{WithSecretKeyring(keyring)}
+}
+
+func envOrDefault(key string, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,214 @@
+package fxconsul
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// ProfileRoot is the KV root of the profile hierarchy
+const ProfileRoot = "config"
+
+// watchWaitTime is the long poll timeout of the configuration watch
+const watchWaitTime = 30 * time.Second
+
+// ProfileEnvironments are the environment names recognized by default in the shared layer: config/shared/prod
+// holds the production overrides and is not part of config/shared for the other environments
+var ProfileEnvironments = []string{"local", "dev", "development", "test", "qa", "uat", "staging", "stage", "preprod", "prod", "production"}
+
+// EffectiveSetting is a merged setting together with the layer it was resolved from
+type EffectiveSetting struct {
+	Value       string `json:"value" yaml:"value"`
+	Layer       string `json:"layer" yaml:"layer"`
+	ModifyIndex uint64 `json:"modifyIndex" yaml:"modifyIndex"`
+}
+
+// ProfilePaths returns the key paths of a service environment, most specific first:
+// config/{service}/{env} → config/{service}/default → config/shared/{env} → config/shared
+func ProfilePaths(service string, env string) []string {
+	return []string{
+		ProfileRoot + "/" + service + "/" + env,
+		ProfileRoot + "/" + service + "/default",
+		ProfileRoot + "/shared/" + env,
+		ProfileRoot + "/shared",
+	}
+}
+
+// WithProfile resolves settings through the profile hierarchy of the service and environment.
+// Writes go to the most specific layer, config/{service}/{env}.
+func WithProfile(service string, env string) Option {
+	return WithLayers(ProfilePaths(service, env)...)
+}
+
+// WithProfileEnvironments replaces the environment names (ProfileEnvironments) whose subtrees are excluded
+// from a layer that also holds the current environment's layer
+func WithProfileEnvironments(environments ...string) Option {
+	return func(o *clientOptions) {
+		o.environments = environments
+	}
+}
+
+// WithLayers resolves settings through the given KV paths, most specific first.
+// A key missing from a layer is looked up in the next one; writes go to the first layer.
+func WithLayers(paths ...string) Option {
+	return func(o *clientOptions) {
+		o.layers = make([]string, 0, len(paths))
+		for _, path := range paths {
+			if path = strings.Trim(path, "/"); path != "" {
+				o.layers = append(o.layers, path)
+			}
+		}
+		if len(o.layers) > 0 {
+			o.basePath = o.layers[0]
+		}
+	}
+}
+
+// Layers returns the KV paths settings are resolved from, most specific first
+func (c *ConsulClient) Layers() []string {
+	return append([]string(nil), c.layers...)
+}
+
+// EffectiveSettings reads every layer from Consul and returns the merged configuration with the origin of each key
+func (c *ConsulClient) EffectiveSettings() (map[string]EffectiveSetting, error) {
+	if err := c.requireConsul(); err != nil {
+		return nil, err
+	}
+	pairs, _, err := c.listLayers(context.Background(), 0)
+	if err != nil {
+		return nil, err
+	}
+	settings := make(map[string]EffectiveSetting)
+	for i := len(c.layers) - 1; i >= 0; i-- {
+		for _, pair := range pairs {
+			if key, ok := c.layerKey(i, pair.Key); ok {
+				settings[key] = EffectiveSetting{Value: string(pair.Value), Layer: c.layers[i], ModifyIndex: pair.ModifyIndex}
+			}
+		}
+	}
+	return settings, nil
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+
+// watchPrefixes are the layer paths, with their trailing "/", that are not nested in another layer:
+// listing them observes every layer and nothing else
+func (c *ConsulClient) watchPrefixes() []string {
+	prefixes := make([]string, 0, len(c.layers))
+	for i, layer := range c.layers {
+		nested := false
+		for j, other := range c.layers {
+			if j != i && strings.HasPrefix(layer+"/", other+"/") && (len(other) < len(layer) || j < i) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			prefixes = append(prefixes, layer+"/")
+		}
+	}
+	return prefixes
+}
+
+// listLayers lists the pairs of every layer with the highest index of the listed prefixes. Given a wait index,
+// it blocks until a layer changes or the wait time elapses.
+func (c *ConsulClient) listLayers(ctx context.Context, waitIndex uint64) (api.KVPairs, uint64, error) {
+	prefixes := c.watchPrefixes()
+	if waitIndex > 0 && len(prefixes) > 1 {
+		if err := c.waitLayers(ctx, prefixes, waitIndex); err != nil {
+			return nil, 0, err
+		}
+		waitIndex = 0
+	}
+	var pairs api.KVPairs
+	var index uint64
+	for _, prefix := range prefixes {
+		opts := (&api.QueryOptions{WaitIndex: waitIndex, WaitTime: watchWaitTime}).WithContext(ctx)
+		listed, meta, err := c.client.KV().List(prefix, opts)
+		if err != nil {
+			return nil, 0, err
+		}
+		pairs = append(pairs, listed...)
+		index = max(index, meta.LastIndex)
+	}
+	return pairs, index, nil
+}
+
+// waitLayers runs a blocking query per prefix and returns as soon as one of them does
+func (c *ConsulClient) waitLayers(ctx context.Context, prefixes []string, waitIndex uint64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan error, len(prefixes))
+	for _, prefix := range prefixes {
+		go func() {
+			opts := (&api.QueryOptions{WaitIndex: waitIndex, WaitTime: watchWaitTime}).WithContext(ctx)
+			_, _, err := c.client.KV().Keys(prefix, "", opts)
+			results <- err
+		}()
+	}
+	return <-results
+}
+
+// layerKey returns the key of fullKey relative to layer i. Keys that belong to a more specific layer
+// nested under it (config/shared/dev inside config/shared), or to another environment next to that layer
+// (config/shared/prod), are not part of the layer.
+func (c *ConsulClient) layerKey(i int, fullKey string) (string, bool) {
+	layer := c.layers[i] + "/"
+	if !strings.HasPrefix(fullKey, layer) || len(fullKey) == len(layer) || strings.HasSuffix(fullKey, "/") {
+		return "", false
+	}
+	holdsEnvironment := false
+	for j, other := range c.layers {
+		if j == i || !strings.HasPrefix(other+"/", layer) {
+			continue
+		}
+		if strings.HasPrefix(fullKey, other+"/") {
+			return "", false
+		}
+		if child := other[len(layer):]; slices.Contains(c.envs, child) {
+			holdsEnvironment = true
+		}
+	}
+	if holdsEnvironment {
+		if child, _, nested := strings.Cut(fullKey[len(layer):], "/"); nested && slices.Contains(c.envs, child) {
+			return "", false
+		}
+	}
+	return fullKey[len(layer):], true
+}
+
+// lookupLayers reads a key from the first layer that defines it. The layers are read concurrently.
+func (c *ConsulClient) lookupLayers(ctx context.Context, key string) (string, bool, error) {
+	type result struct {
+		pair *api.KVPair
+		err  error
+	}
+	results := make([]chan result, len(c.layers))
+	for i, layer := range c.layers {
+		results[i] = make(chan result, 1)
+		if _, ok := c.layerKey(i, layer+"/"+key); !ok {
+			results[i] <- result{} // Belongs to a nested layer or another environment
+			continue
+		}
+		go func() {
+			pair, _, err := c.client.KV().Get(layer+"/"+key, (&api.QueryOptions{}).WithContext(ctx))
+			results[i] <- result{pair: pair, err: err}
+		}()
+	}
+	for i, layer := range c.layers {
+		r := <-results[i]
+		if r.err != nil {
+			return "", false, fmt.Errorf("layer %s: %w", layer, r.err)
+		}
+		if r.pair != nil && len(r.pair.Value) > 0 {
+			return string(r.pair.Value), true, nil
+		}
+	}
+	return "", false, nil
+}
@@ -0,0 +1,96 @@
+package fxconsul_test
+
+import (
+	"testing"
+
+	"github.com/tacjlee/common-sdk/packages/fxconsul"
+	"github.com/tacjlee/common-sdk/packages/fxconsul/consultest"
+)
+
+// setProfile writes one key per layer of the billing profiles, plus a production override in the shared layer
+func setProfile(server *consultest.Server) {
+	server.SetAll(map[string]string{
+		"config/billing/dev/db/host":     "dev-db",
+		"config/billing/default/db/host": "default-db",
+		"config/billing/default/db/pool": "10",
+		"config/shared/dev/log/level":    "debug",
+		"config/shared/log/level":        "info",
+		"config/shared/log/format":       "json",
+		"config/shared/prod/db/pool":     "50",
+		"config/shared/prod/log/format":  "text",
+	})
+}
+
+func TestProfileResolvesMostSpecificLayerFirst(t *testing.T) {
+	server := consultest.NewServer(t)
+	setProfile(server)
+	client := newClient(t, server, fxconsul.WithProfile("billing", "dev"))
+
+	expected := map[string]string{
+		"db/host":      "dev-db",   // config/billing/dev
+		"db/pool":      "10",       // config/billing/default
+		"log/level":    "debug",    // config/shared/dev
+		"log/format":   "json",     // config/shared, not config/shared/prod
+		"prod/db/pool": "fallback", // Another environment's subtree is not part of config/shared
+	}
+	for key, want := range expected {
+		if got := client.GetSetting(key, "fallback"); got != want {
+			t.Errorf("GetSetting(%s) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestEffectiveSettingsReportLayers(t *testing.T) {
+	server := consultest.NewServer(t)
+	setProfile(server)
+	client := newClient(t, server, fxconsul.WithProfile("billing", "prod"))
+
+	settings, err := client.EffectiveSettings()
+	if err != nil {
+		t.Fatalf("EffectiveSettings: %v", err)
+	}
+	expected := map[string]fxconsul.EffectiveSetting{
+		"db/host":    {Value: "default-db", Layer: "config/billing/default"},
+		"db/pool":    {Value: "10", Layer: "config/billing/default"},
+		"log/level":  {Value: "info", Layer: "config/shared"},
+		"log/format": {Value: "text", Layer: "config/shared/prod"},
+	}
+	if len(settings) != len(expected) {
+		t.Errorf("EffectiveSettings returned %d keys, want %d: %v", len(settings), len(expected), settings)
+	}
+	for key, want := range expected {
+		got := settings[key]
+		if got.Value != want.Value || got.Layer != want.Layer {
+			t.Errorf("EffectiveSettings[%s] = %s from %s, want %s from %s", key, got.Value, got.Layer, want.Value, want.Layer)
+		}
+	}
+}
+
+func TestWatchObservesEveryLayer(t *testing.T) {
+	server := consultest.NewServer(t)
+	setProfile(server)
+	client := newClient(t, server, fxconsul.WithProfile("billing", "dev"))
+	changes := subscribe(client, "")
+	watch(t, server, client)
+
+	// Overriding a shared key in the service layer changes its effective value
+	server.Set("config/billing/dev/log/format", "logfmt")
+	cs := receive(t, changes)
+	if change, ok := cs.Get("log/format"); !ok || change.OldValue != "json" || change.NewValue != "logfmt" {
+		t.Fatalf("change set %+v does not move log/format from json to logfmt", cs)
+	}
+
+	// Removing the override falls back to the shared layer
+	server.Delete("config/billing/dev/log/format")
+	cs = receive(t, changes)
+	if change, ok := cs.Get("log/format"); !ok || change.Type != fxconsul.ChangeModified || change.NewValue != "json" {
+		t.Fatalf("change set %+v does not restore log/format to json", cs)
+	}
+
+	// Another environment's override is not a change of this profile
+	server.Set("config/shared/prod/log/format", "yaml")
+	expectNone(t, changes)
+	if got := client.GetSetting("log/format", ""); got != "json" {
+		t.Errorf("GetSetting(log/format) = %q, want json", got)
+	}
+}
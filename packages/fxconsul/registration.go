@@ -0,0 +1,220 @@
+package fxconsul
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/consul/api"
+)
+
+// ServiceDefinition describes a service instance registered with the local Consul agent
+type ServiceDefinition struct {
+	ID      string // Defaults to name-hostname-port
+	Name    string
+	Tags    []string
+	Meta    map[string]string
+	Address string // Defaults to the agent address
+	Port    int
+
+	// HTTP health check, e.g. "/health" (resolved against Address:Port) or a full URL
+	HealthCheckPath string
+	CheckInterval   time.Duration // Defaults to 10s
+	CheckTimeout    time.Duration // Defaults to 5s
+
+	// TTL health check (at least 1s) refreshed by a heartbeat goroutine; HealthFunc decides pass/fail when set
+	TTL        time.Duration
+	HealthFunc func() error
+
+	DeregisterCriticalAfter time.Duration // Defaults to 1m
+}
+
+// minServiceTTL is the shortest TTL check accepted, heartbeats being sent every TTL/2
+const minServiceTTL = time.Second
+
+// ServiceRegistration is a registered service instance kept alive in the background
+type ServiceRegistration struct {
+	client     *ConsulClient
+	definition ServiceDefinition
+	cancel     context.CancelFunc
+	done       chan struct{}
+	unclose    func()
+
+	mu           sync.Mutex // Serializes Deregister
+	stopped      bool       // The background maintenance has exited
+	deregistered bool
+}
+
+// RegisterService registers the service with the local agent, keeps its TTL check passing and
+// re-registers it when the agent loses it (e.g. after an agent restart). The service is deregistered
+// when ctx is cancelled, Deregister is called or the client is closed.
+func (c *ConsulClient) RegisterService(ctx context.Context, definition ServiceDefinition) (*ServiceRegistration, error) {
+	if c.client == nil {
+		return nil, fmt.Errorf("consul client is not configured")
+	}
+	if definition.Name == "" {
+		return nil, fmt.Errorf("service name is required")
+	}
+	if definition.TTL < 0 || (definition.TTL > 0 && definition.TTL < minServiceTTL) {
+		return nil, fmt.Errorf("service TTL %s is invalid, it must be at least %s", definition.TTL, minServiceTTL)
+	}
+	if definition.ID == "" {
+		hostname, _ := os.Hostname()
+		definition.ID = definition.Name + "-" + hostname + "-" + strconv.Itoa(definition.Port)
+	}
+	if definition.CheckInterval <= 0 {
+		definition.CheckInterval = 10 * time.Second
+	}
+	if definition.CheckTimeout <= 0 {
+		definition.CheckTimeout = 5 * time.Second
+	}
+	if definition.DeregisterCriticalAfter <= 0 {
+		definition.DeregisterCriticalAfter = time.Minute
+	}
+
+	registration := &ServiceRegistration{client: c, definition: definition, done: make(chan struct{})}
+	if err := registration.register(); err != nil {
+		return nil, err
+	}
+	c.logger.Printf("Registered service %s (%s) with Consul", definition.Name, definition.ID)
+
+	ctx, cancel := context.WithCancel(ctx)
+	registration.cancel = cancel
+	go registration.maintain(ctx)
+	registration.unclose = c.onClose(func() {
+		_ = registration.Deregister()
+	})
+	return registration, nil
+}
+
+// ID returns the service instance ID
+func (r *ServiceRegistration) ID() string {
+	return r.definition.ID
+}
+
+// Deregister stops the heartbeat and removes the service from the agent.
+// A failed deregistration is retried by the next call.
+func (r *ServiceRegistration) Deregister() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.deregistered {
+		return nil
+	}
+	if !r.stopped {
+		r.cancel()
+		<-r.done
+		r.unclose()
+		r.stopped = true
+	}
+	if err := r.client.client.Agent().ServiceDeregister(r.definition.ID); err != nil {
+		return err
+	}
+	r.deregistered = true
+	r.client.logger.Printf("Deregistered service %s from Consul", r.definition.ID)
+	return nil
+}
+
+// RegisterHealthEndpoint adds a GET endpoint answering 200 for the HTTP health check
+func RegisterHealthEndpoint(router gin.IRoutes, path string) {
+	router.GET(path, func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{"status": "UP"})
+	})
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+func (r *ServiceRegistration) ttlCheckID() string {
+	return "service:" + r.definition.ID + ":ttl"
+}
+
+func (r *ServiceRegistration) register() error {
+	d := r.definition
+	registration := &api.AgentServiceRegistration{
+		ID:      d.ID,
+		Name:    d.Name,
+		Tags:    d.Tags,
+		Meta:    d.Meta,
+		Address: d.Address,
+		Port:    d.Port,
+	}
+	deregisterAfter := d.DeregisterCriticalAfter.String()
+	if d.HealthCheckPath != "" {
+		url := d.HealthCheckPath
+		if url[0] == '/' {
+			host := d.Address
+			if host == "" {
+				host = "127.0.0.1"
+			}
+			url = "http://" + host + ":" + strconv.Itoa(d.Port) + d.HealthCheckPath
+		}
+		registration.Checks = append(registration.Checks, &api.AgentServiceCheck{
+			Name:                           d.Name + " HTTP",
+			HTTP:                           url,
+			Interval:                       d.CheckInterval.String(),
+			Timeout:                        d.CheckTimeout.String(),
+			DeregisterCriticalServiceAfter: deregisterAfter,
+		})
+	}
+	if d.TTL > 0 {
+		registration.Checks = append(registration.Checks, &api.AgentServiceCheck{
+			CheckID:                        r.ttlCheckID(),
+			Name:                           d.Name + " TTL",
+			TTL:                            d.TTL.String(),
+			DeregisterCriticalServiceAfter: deregisterAfter,
+		})
+	}
+	return r.client.client.Agent().ServiceRegister(registration)
+}
+
+// maintain sends TTL heartbeats and re-registers the service when the agent no longer knows it
+func (r *ServiceRegistration) maintain(ctx context.Context) {
+	defer close(r.done)
+	interval := r.definition.CheckInterval
+	if r.definition.TTL > 0 {
+		interval = r.definition.TTL / 2
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	r.heartbeat()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Deregister when the caller's context ends; explicit Deregister waits on done instead
+			go func() { _ = r.Deregister() }()
+			return
+		case <-ticker.C:
+		}
+
+		service, _, err := r.client.client.Agent().Service(r.definition.ID, nil)
+		if err != nil || service == nil {
+			if registerErr := r.register(); registerErr != nil {
+				r.client.logger.Printf("Warning: Failed to re-register service %s: %v", r.definition.ID, registerErr)
+				continue
+			}
+			r.client.logger.Printf("Re-registered service %s with Consul", r.definition.ID)
+		}
+		r.heartbeat()
+	}
+}
+
+func (r *ServiceRegistration) heartbeat() {
+	if r.definition.TTL <= 0 {
+		return
+	}
+	status, output := api.HealthPassing, "ok"
+	if r.definition.HealthFunc != nil {
+		if err := r.definition.HealthFunc(); err != nil {
+			status, output = api.HealthCritical, err.Error()
+		}
+	}
+	if err := r.client.client.Agent().UpdateTTL(r.ttlCheckID(), output, status); err != nil {
+		r.client.logger.Printf("Warning: Failed to update TTL check of %s: %v", r.definition.ID, err)
+	}
+}
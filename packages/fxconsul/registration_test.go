@@ -0,0 +1,33 @@
+package fxconsul_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tacjlee/common-sdk/packages/fxconsul"
+	"github.com/tacjlee/common-sdk/packages/fxconsul/consultest"
+)
+
+func TestDeregisterRetriesAfterFailure(t *testing.T) {
+	server := consultest.NewServer(t)
+	client := newClient(t, server)
+	registration, err := client.RegisterService(context.Background(), fxconsul.ServiceDefinition{ID: "billing-1", Name: "billing", Port: 8080})
+	if err != nil {
+		t.Fatalf("RegisterService: %v", err)
+	}
+
+	server.SetAvailable(false)
+	if err := registration.Deregister(); err == nil {
+		t.Fatal("Deregister succeeded while the agent was unavailable")
+	}
+	server.SetAvailable(true)
+	if err := registration.Deregister(); err != nil {
+		t.Fatalf("Deregister after the agent came back: %v", err)
+	}
+	if _, registered := server.Service("billing-1"); registered {
+		t.Error("the service is still registered")
+	}
+	if err := registration.Deregister(); err != nil {
+		t.Errorf("Deregister of a deregistered service = %v, want nil", err)
+	}
+}
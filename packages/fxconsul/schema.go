@@ -0,0 +1,437 @@
+package fxconsul
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SettingType is the type a setting value must parse as
+type SettingType string
+
+const (
+	TypeString   SettingType = "string"
+	TypeInt      SettingType = "int"
+	TypeFloat    SettingType = "float"
+	TypeBool     SettingType = "bool"
+	TypeDuration SettingType = "duration"
+	TypeList     SettingType = "list" // JSON array or comma separated
+	TypeMap      SettingType = "map"  // JSON object or key=value pairs
+	TypeJSON     SettingType = "json"
+)
+
+// SettingSchema declares a setting. Min and Max bound numbers and durations (parsed with the setting's type),
+// the length of strings and the number of items of lists.
+type SettingSchema struct {
+	Key         string
+	Type        SettingType
+	Required    bool
+	Default     string
+	Description string
+	Secret      bool     // Values are redacted in reports
+	Min         string   // Inclusive lower bound, e.g. "1", "0.5" or "100ms"
+	Max         string   // Inclusive upper bound
+	Pattern     string   // Regular expression the value (or each list item) must match
+	Enum        []string // Allowed values (or list items)
+}
+
+// ConfigSchema is the set of declared settings, validated at startup and on every watched change
+type ConfigSchema struct {
+	settings map[string]SettingSchema
+	patterns map[string]*regexp.Regexp
+	keys     []string
+}
+
+// SchemaViolation is a setting whose value does not satisfy its schema
+type SchemaViolation struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Error string `json:"error"`
+}
+
+// SchemaReport lists the settings that do not match the schema
+type SchemaReport struct {
+	CheckedAt time.Time         `json:"checkedAt"`
+	Missing   []string          `json:"missing"`
+	Unknown   []string          `json:"unknown"`
+	Invalid   []SchemaViolation `json:"invalid"`
+	Rejected  []SchemaViolation `json:"rejected"` // Watched updates refused; the last valid value is still served
+}
+
+// NewConfigSchema builds a schema, checking that every declaration and default is itself valid
+func NewConfigSchema(settings ...SettingSchema) (*ConfigSchema, error) {
+	schema := &ConfigSchema{
+		settings: make(map[string]SettingSchema, len(settings)),
+		patterns: make(map[string]*regexp.Regexp),
+	}
+	var errs []error
+	for _, setting := range settings {
+		if setting.Key == "" {
+			errs = append(errs, fmt.Errorf("schema entry without key"))
+			continue
+		}
+		if _, exists := schema.settings[setting.Key]; exists {
+			errs = append(errs, fmt.Errorf("%s: declared twice", setting.Key))
+			continue
+		}
+		if setting.Type == "" {
+			setting.Type = TypeString
+		}
+		if setting.Pattern != "" {
+			pattern, err := regexp.Compile(setting.Pattern)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid pattern: %w", setting.Key, err))
+				continue
+			}
+			schema.patterns[setting.Key] = pattern
+		}
+		schema.settings[setting.Key] = setting
+		schema.keys = append(schema.keys, setting.Key)
+		if setting.Default != "" {
+			if err := schema.check(setting.Key, setting.Default); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid default: %w", setting.Key, err))
+			}
+		}
+	}
+	sort.Strings(schema.keys)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return schema, nil
+}
+
+// Settings returns the declared settings sorted by key
+func (s *ConfigSchema) Settings() []SettingSchema {
+	result := make([]SettingSchema, 0, len(s.keys))
+	for _, key := range s.keys {
+		result = append(result, s.settings[key])
+	}
+	return result
+}
+
+// WithSchema validates the configuration against the schema at startup and on every watched change.
+// In strict mode (WithStrictSettings) NewConsulClient fails when required keys are missing or values are invalid.
+// Declared defaults are served by GetSetting when it is called with an empty defaultValue, and by the typed
+// getters and Bind ahead of their own default.
+func WithSchema(schema *ConfigSchema) Option {
+	return func(o *clientOptions) {
+		o.schema = schema
+	}
+}
+
+// OK reports whether no setting is missing or invalid
+func (r SchemaReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Invalid) == 0
+}
+
+// String renders the report for logs and the console
+func (r SchemaReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Configuration report (%s): %d missing, %d invalid, %d unknown, %d rejected\n",
+		r.CheckedAt.Format(time.RFC3339), len(r.Missing), len(r.Invalid), len(r.Unknown), len(r.Rejected))
+	for _, key := range r.Missing {
+		fmt.Fprintf(&sb, "  missing  %s\n", key)
+	}
+	for _, violation := range r.Invalid {
+		fmt.Fprintf(&sb, "  invalid  %s=%q: %s\n", violation.Key, violation.Value, violation.Error)
+	}
+	for _, key := range r.Unknown {
+		fmt.Fprintf(&sb, "  unknown  %s\n", key)
+	}
+	for _, violation := range r.Rejected {
+		fmt.Fprintf(&sb, "  rejected %s=%q: %s\n", violation.Key, violation.Value, violation.Error)
+	}
+	return sb.String()
+}
+
+// ValidateSchema checks every declared setting as GetSetting resolves it (Consul, snapshot, environment, schema default)
+// and lists the Consul keys that the schema does not declare
+func (c *ConsulClient) ValidateSchema() SchemaReport {
+	report := SchemaReport{CheckedAt: time.Now()}
+	if c.schema == nil {
+		return report
+	}
+	for _, key := range c.schema.keys {
+		setting := c.schema.settings[key]
+		value := c.GetSetting(key, "")
+		if value == "" {
+			if setting.Required {
+				report.Missing = append(report.Missing, key)
+			}
+			continue
+		}
+		if err := c.checkSetting(key, value); err != nil {
+			report.Invalid = append(report.Invalid, c.schema.violation(key, value, err))
+		}
+	}
+	for _, key := range c.knownKeys() {
+		if _, declared := c.schema.settings[key]; !declared {
+			report.Unknown = append(report.Unknown, key)
+		}
+	}
+	report.Rejected = c.rejectedUpdates()
+
+	c.schemaMu.Lock()
+	c.schemaReport = report
+	c.schemaMu.Unlock()
+	return report
+}
+
+// SchemaReport returns the report of the last validation, including updates rejected since then
+func (c *ConsulClient) SchemaReport() SchemaReport {
+	c.schemaMu.Lock()
+	report := c.schemaReport
+	c.schemaMu.Unlock()
+	report.Rejected = c.rejectedUpdates()
+	return report
+}
+
+// SchemaReportHandler serves a fresh validation report; it answers 503 when settings are missing or invalid
+func (c *ConsulClient) SchemaReportHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		report := c.ValidateSchema()
+		status := http.StatusOK
+		if !report.OK() {
+			status = http.StatusServiceUnavailable
+		}
+		ctx.JSON(status, report)
+	}
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+
+// pinnedValue is the last valid value of a key whose latest update was rejected
+type pinnedValue struct {
+	value    string
+	found    bool
+	rejected SchemaViolation
+	change   KeyChange // The rejected change, so that a listing repeating it is not reported again
+}
+
+// validateAtStartup logs the schema report and, in strict mode, turns it into an error
+func (c *ConsulClient) validateAtStartup() error {
+	if c.schema == nil {
+		return nil
+	}
+	report := c.ValidateSchema()
+	if report.OK() && len(report.Unknown) == 0 {
+		return nil
+	}
+	c.logger.Printf("Warning: %s", strings.TrimRight(report.String(), "\n"))
+	if !report.OK() && c.strict {
+		return fmt.Errorf("configuration does not match the schema: %d missing, %d invalid", len(report.Missing), len(report.Invalid))
+	}
+	return nil
+}
+
+// rejectInvalid removes the changes that break the schema from the change set and keeps their previous entry
+// in current, so that the last valid value keeps being served. The previous entry is detected as changed
+// again on every listing; rejected only holds the rejections that differ from the one already pinned.
+func (c *ConsulClient) rejectInvalid(changes ChangeSet, previous map[string]kvEntry, current map[string]kvEntry) (accepted ChangeSet, rejected ChangeSet) {
+	if c.schema == nil {
+		return changes, ChangeSet{Index: changes.Index}
+	}
+	accepted = ChangeSet{Index: changes.Index}
+	rejected = ChangeSet{Index: changes.Index}
+	c.pinnedMu.Lock()
+	defer c.pinnedMu.Unlock()
+	for _, change := range changes.Changes {
+		var err error
+		if change.Type == ChangeDeleted {
+			if setting, declared := c.schema.settings[change.Key]; declared && setting.Required && setting.Default == "" {
+				err = fmt.Errorf("required setting cannot be deleted")
+			}
+		} else if _, declared := c.schema.settings[change.Key]; declared {
+			err = c.checkSetting(change.Key, change.NewValue)
+		}
+		if err == nil {
+			delete(c.pinned, change.Key)
+			accepted.Changes = append(accepted.Changes, change)
+			continue
+		}
+
+		violation := c.schema.violation(change.Key, change.NewValue, err)
+		if pinned, ok := c.pinned[change.Key]; !ok || pinned.change.Type != change.Type || pinned.change.NewValue != change.NewValue {
+			c.logger.Printf("Warning: Rejected update of %s: %s - keeping the last valid value", change.Key, violation.Error)
+			rejected.Changes = append(rejected.Changes, change)
+		}
+		old, existed := previous[change.Key]
+		if existed {
+			current[change.Key] = old
+		} else {
+			delete(current, change.Key)
+		}
+		c.pinned[change.Key] = pinnedValue{value: old.value, found: existed, rejected: violation, change: change}
+	}
+	return accepted, rejected
+}
+
+// lookupPinned serves the last valid value of a key whose update was rejected
+func (c *ConsulClient) lookupPinned(key string) (value string, found bool, pinned bool) {
+	c.pinnedMu.RLock()
+	defer c.pinnedMu.RUnlock()
+	entry, ok := c.pinned[key]
+	return entry.value, entry.found, ok
+}
+
+func (c *ConsulClient) rejectedUpdates() []SchemaViolation {
+	c.pinnedMu.RLock()
+	defer c.pinnedMu.RUnlock()
+	result := make([]SchemaViolation, 0, len(c.pinned))
+	for _, entry := range c.pinned {
+		result = append(result, entry.rejected)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// knownKeys lists the keys of the client's layers currently stored in Consul (or in the last snapshot when it
+// is unavailable); other services and the other environments of the shared layer are not part of them
+func (c *ConsulClient) knownKeys() []string {
+	var keys []string
+	if settings, err := c.EffectiveSettings(); err == nil {
+		for key := range settings {
+			keys = append(keys, key)
+		}
+	} else if c.snapshots != nil {
+		c.snapshots.mu.RLock()
+		for key := range c.snapshots.values {
+			keys = append(keys, key)
+		}
+		c.snapshots.mu.RUnlock()
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkSetting validates a value, decrypting secrets first
+func (c *ConsulClient) checkSetting(key string, value string) error {
+	value, err := c.decryptValue(value)
+	if err != nil {
+		return err
+	}
+	return c.schema.check(key, value)
+}
+
+func (s *ConfigSchema) violation(key string, value string, err error) SchemaViolation {
+	if s.settings[key].Secret || IsEncrypted(value) {
+		value = redactedValue
+	}
+	return SchemaViolation{Key: key, Value: value, Error: err.Error()}
+}
+
+// check parses the value with the declared type and applies the constraints
+func (s *ConfigSchema) check(key string, value string) error {
+	setting := s.settings[key]
+	value = strings.TrimSpace(value)
+	switch setting.Type {
+	case TypeInt, TypeFloat, TypeDuration:
+		number, err := parseSchemaNumber(setting.Type, value)
+		if err != nil {
+			return err
+		}
+		return checkBounds(setting, number, func(bound string) (float64, error) {
+			return parseSchemaNumber(setting.Type, bound)
+		})
+	case TypeBool:
+		_, err := parseBool(value)
+		return err
+	case TypeList:
+		items, err := splitList(value)
+		if err != nil {
+			return err
+		}
+		if err := checkBounds(setting, float64(len(items)), parseCount); err != nil {
+			return fmt.Errorf("item count: %w", err)
+		}
+		for _, item := range items {
+			if err := s.checkText(key, item); err != nil {
+				return fmt.Errorf("item %q: %w", item, err)
+			}
+		}
+		return nil
+	case TypeMap:
+		_, err := splitMap(value)
+		return err
+	case TypeJSON:
+		if !json.Valid([]byte(value)) {
+			return fmt.Errorf("invalid JSON")
+		}
+		return nil
+	case TypeString:
+		if err := checkBounds(setting, float64(len([]rune(value))), parseCount); err != nil {
+			return fmt.Errorf("length: %w", err)
+		}
+		return s.checkText(key, value)
+	}
+	return fmt.Errorf("unknown setting type %q", setting.Type)
+}
+
+func (s *ConfigSchema) checkText(key string, value string) error {
+	setting := s.settings[key]
+	if pattern, ok := s.patterns[key]; ok && !pattern.MatchString(value) {
+		return fmt.Errorf("does not match %s", setting.Pattern)
+	}
+	if len(setting.Enum) > 0 {
+		for _, allowed := range setting.Enum {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(setting.Enum, ", "))
+	}
+	return nil
+}
+
+func checkBounds(setting SettingSchema, value float64, parse func(string) (float64, error)) error {
+	if setting.Min != "" {
+		bound, err := parse(setting.Min)
+		if err != nil {
+			return fmt.Errorf("invalid min %q: %w", setting.Min, err)
+		}
+		if value < bound {
+			return fmt.Errorf("must be at least %s", setting.Min)
+		}
+	}
+	if setting.Max != "" {
+		bound, err := parse(setting.Max)
+		if err != nil {
+			return fmt.Errorf("invalid max %q: %w", setting.Max, err)
+		}
+		if value > bound {
+			return fmt.Errorf("must be at most %s", setting.Max)
+		}
+	}
+	return nil
+}
+
+// parseSchemaNumber parses ints, floats and durations (as nanoseconds) for bound comparisons
+func parseSchemaNumber(settingType SettingType, value string) (float64, error) {
+	switch settingType {
+	case TypeInt:
+		var intValue int
+		result, err := parseIntValue(value, &intValue)
+		return float64(result), err
+	case TypeDuration:
+		duration, err := parseDuration(value)
+		return float64(duration), err
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+func parseCount(value string) (float64, error) {
+	count, err := strconv.Atoi(value)
+	return float64(count), err
+}
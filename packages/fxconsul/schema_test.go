@@ -0,0 +1,133 @@
+package fxconsul_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tacjlee/common-sdk/packages/fxconsul"
+	"github.com/tacjlee/common-sdk/packages/fxconsul/consultest"
+)
+
+func testSchema(t *testing.T) *fxconsul.ConfigSchema {
+	t.Helper()
+	schema, err := fxconsul.NewConfigSchema(
+		fxconsul.SettingSchema{Key: "http/port", Type: fxconsul.TypeInt, Required: true, Min: "1", Max: "65535"},
+		fxconsul.SettingSchema{Key: "http/timeout", Type: fxconsul.TypeDuration, Default: "5s"},
+		fxconsul.SettingSchema{Key: "api/token", Secret: true, Pattern: "^tk-"},
+	)
+	if err != nil {
+		t.Fatalf("NewConfigSchema: %v", err)
+	}
+	return schema
+}
+
+func TestNewConfigSchemaChecksDefaults(t *testing.T) {
+	_, err := fxconsul.NewConfigSchema(
+		fxconsul.SettingSchema{Key: "retries", Type: fxconsul.TypeInt, Default: "many"},
+		fxconsul.SettingSchema{Key: "retries"},
+	)
+	if err == nil {
+		t.Fatal("NewConfigSchema accepted an invalid default and a duplicate key")
+	}
+}
+
+func TestSchemaDefaultFallback(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.Set("config/dev/settings/http/port", "8080")
+	client := newClient(t, server, fxconsul.WithSchema(testSchema(t)))
+
+	if got := client.GetSetting("http/timeout", ""); got != "5s" {
+		t.Errorf("GetSetting(http/timeout, \"\") = %q, want the schema default 5s", got)
+	}
+	if got := client.GetSetting("http/timeout", "9s"); got != "9s" {
+		t.Errorf("GetSetting(http/timeout, 9s) = %q, want the caller's default 9s", got)
+	}
+	if got := client.GetSettingDuration("http/timeout", 9*time.Second); got != 5*time.Second {
+		t.Errorf("GetSettingDuration(http/timeout) = %s, want the schema default 5s", got)
+	}
+}
+
+func TestStrictSettingsFailOnMissingRequiredKey(t *testing.T) {
+	server := consultest.NewServer(t)
+	options := []fxconsul.Option{
+		fxconsul.WithAddress(server.Address()),
+		fxconsul.WithLogger(discardLogger),
+		fxconsul.WithSchema(testSchema(t)),
+		fxconsul.WithStrictSettings(true),
+	}
+	if client, err := fxconsul.NewConsulClient(options...); err == nil {
+		client.Close()
+		t.Fatal("NewConsulClient succeeded without the required http/port")
+	}
+
+	server.Set("config/dev/settings/http/port", "8080")
+	client, err := fxconsul.NewConsulClient(options...)
+	if err != nil {
+		t.Fatalf("NewConsulClient: %v", err)
+	}
+	client.Close()
+}
+
+func TestValidateSchemaListsUnknownKeysOfTheClientLayers(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.SetAll(map[string]string{
+		"config/dev/settings/http/port":   "8080",
+		"config/dev/settings/http/legacy": "true",
+		"config/other/settings/unrelated": "x",
+	})
+	client := newClient(t, server, fxconsul.WithSchema(testSchema(t)))
+
+	report := client.ValidateSchema()
+	if !report.OK() {
+		t.Errorf("report is not OK: %s", report)
+	}
+	if !reflect.DeepEqual(report.Unknown, []string{"http/legacy"}) {
+		t.Errorf("unknown keys = %v, want [http/legacy]", report.Unknown)
+	}
+}
+
+func TestSchemaRejectsInvalidUpdates(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.Set("config/dev/settings/http/port", "8080")
+	client := newClient(t, server, fxconsul.WithSchema(testSchema(t)))
+	changes := subscribe(client, "")
+	watch(t, server, client)
+
+	// The invalid value is rejected; the valid change of the same transaction is applied
+	server.SetAll(map[string]string{
+		"config/dev/settings/http/port": "http",
+		"config/dev/settings/log/level": "debug",
+	})
+	if got := receive(t, changes).Keys(); !reflect.DeepEqual(got, []string{"log/level"}) {
+		t.Fatalf("notified keys = %v, want [log/level]", got)
+	}
+	if got := client.GetSetting("http/port", ""); got != "8080" {
+		t.Errorf("GetSetting(http/port) = %q, want the last valid value 8080", got)
+	}
+	rejected := client.SchemaReport().Rejected
+	if len(rejected) != 1 || rejected[0].Key != "http/port" || rejected[0].Value != "http" {
+		t.Errorf("rejected updates = %+v, want http/port=http", rejected)
+	}
+
+	// A required key cannot be deleted
+	server.Delete("config/dev/settings/http/port")
+	server.Set("config/dev/settings/log/level", "info")
+	receive(t, changes)
+	if got := client.GetSetting("http/port", ""); got != "8080" {
+		t.Errorf("GetSetting(http/port) after a delete = %q, want 8080", got)
+	}
+
+	// A valid value is accepted and clears the rejection
+	server.Set("config/dev/settings/http/port", "9090")
+	cs := receive(t, changes)
+	if change, ok := cs.Get("http/port"); !ok || change.OldValue != "8080" || change.NewValue != "9090" {
+		t.Fatalf("change set %+v does not move http/port from 8080 to 9090", cs)
+	}
+	if got := client.GetSetting("http/port", ""); got != "9090" {
+		t.Errorf("GetSetting(http/port) = %q, want 9090", got)
+	}
+	if rejected := client.SchemaReport().Rejected; len(rejected) != 0 {
+		t.Errorf("rejected updates = %+v, want none", rejected)
+	}
+}
@@ -0,0 +1,245 @@
+package fxconsul
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EncryptedPrefix marks envelope-encrypted values: enc:v1:<key version>:<wrapped data key>:<ciphertext>
+const EncryptedPrefix = "enc:v1:"
+
+// SecretKeyring holds the versioned key-encryption keys (KEK) used to wrap per-value data keys.
+// The primary version encrypts new values; every version can decrypt.
+type SecretKeyring struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// NewSecretKeyring creates a keyring from versioned keys (16, 24 or 32 bytes) with the given primary version
+func NewSecretKeyring(primary string, keys map[string][]byte) (*SecretKeyring, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("primary key version %q is not in the keyring", primary)
+	}
+	for version, key := range keys {
+		if strings.Contains(version, ":") || version == "" {
+			return nil, fmt.Errorf("invalid key version %q", version)
+		}
+		if _, err := newGCM(key); err != nil {
+			return nil, fmt.Errorf("key version %s: %w", version, err)
+		}
+	}
+	return &SecretKeyring{primary: primary, keys: keys}, nil
+}
+
+// ParseSecretKeyring parses "version:base64key" entries separated by commas or newlines; the first entry is primary
+func ParseSecretKeyring(spec string) (*SecretKeyring, error) {
+	keys := make(map[string][]byte)
+	primary := ""
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		version, encoded, found := strings.Cut(entry, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid keyring entry, expected version:base64key")
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("key version %s: %w", version, err)
+		}
+		if primary == "" {
+			primary = version
+		}
+		keys[version] = key
+	}
+	if primary == "" {
+		return nil, fmt.Errorf("keyring is empty")
+	}
+	return NewSecretKeyring(primary, keys)
+}
+
+// LoadSecretKeyringFile reads a keyring file in the ParseSecretKeyring format
+func LoadSecretKeyringFile(path string) (*SecretKeyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSecretKeyring(string(data))
+}
+
+// WithSecretKeyring enables decryption of "enc:v1:" values by GetSecret and Bind
+func WithSecretKeyring(keyring *SecretKeyring) Option {
+	return func(o *clientOptions) {
+		o.keyring = keyring
+	}
+}
+
+// IsEncrypted reports whether the value is an envelope-encrypted secret
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, EncryptedPrefix)
+}
+
+// PrimaryVersion returns the key version used for new encryptions
+func (k *SecretKeyring) PrimaryVersion() string {
+	return k.primary
+}
+
+// Encrypt seals the plaintext with a fresh AES-GCM data key wrapped by the primary key
+func (k *SecretKeyring) Encrypt(plaintext string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	ciphertext, err := sealGCM(dataKey, []byte(plaintext), []byte(EncryptedPrefix))
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := sealGCM(k.keys[k.primary], dataKey, []byte(EncryptedPrefix+k.primary))
+	if err != nil {
+		return "", err
+	}
+	return EncryptedPrefix + k.primary + ":" + base64.StdEncoding.EncodeToString(wrapped) + ":" +
+		base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt opens an "enc:v1:" value with the key version it was wrapped with
+func (k *SecretKeyring) Decrypt(value string) (string, error) {
+	version, dataKey, ciphertext, err := k.unwrap(value)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := openSealed(dataKey, ciphertext, []byte(EncryptedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("decrypt secret (key version %s): %w", version, err)
+	}
+	return string(plaintext), nil
+}
+
+// Rewrap re-wraps the data key of the value with the primary key without touching the payload.
+// Used to rotate key-encryption keys; values already on the primary version are returned unchanged.
+func (k *SecretKeyring) Rewrap(value string) (string, error) {
+	version, dataKey, ciphertext, err := k.unwrap(value)
+	if err != nil {
+		return "", err
+	}
+	if version == k.primary {
+		return value, nil
+	}
+	wrapped, err := sealGCM(k.keys[k.primary], dataKey, []byte(EncryptedPrefix+k.primary))
+	if err != nil {
+		return "", err
+	}
+	return EncryptedPrefix + k.primary + ":" + base64.StdEncoding.EncodeToString(wrapped) + ":" +
+		base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// GetSecret returns a setting, decrypting it when it is stored encrypted.
+// Plaintext values are returned as-is so that secrets can be migrated gradually.
+func (c *ConsulClient) GetSecret(key string, defaultValue string) (string, error) {
+	return c.decryptValue(c.GetSetting(key, defaultValue))
+}
+
+// RotateSecrets re-wraps every encrypted value under prefix with the primary key version,
+// using check-and-set so that concurrent updates are not overwritten. Returns the number of rotated keys.
+func (c *ConsulClient) RotateSecrets(prefix string) (int, error) {
+	if c.keyring == nil {
+		return 0, fmt.Errorf("no secret keyring configured")
+	}
+	if err := c.requireConsul(); err != nil {
+		return 0, err
+	}
+	pairs, _, err := c.client.KV().List(c.consulKey(prefix), nil)
+	if err != nil {
+		return 0, err
+	}
+	rotated := 0
+	for _, pair := range pairs {
+		value := string(pair.Value)
+		if !IsEncrypted(value) {
+			continue
+		}
+		rewrapped, err := c.keyring.Rewrap(value)
+		if err != nil {
+			return rotated, fmt.Errorf("%s: %w", pair.Key, err)
+		}
+		if rewrapped == value {
+			continue
+		}
+		key := strings.TrimPrefix(pair.Key, c.basePath+"/")
+		ok, err := c.CompareAndSet(key, rewrapped, pair.ModifyIndex)
+		if err != nil {
+			return rotated, fmt.Errorf("%s: %w", pair.Key, err)
+		}
+		if ok {
+			rotated++
+		}
+	}
+	return rotated, nil
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+func (c *ConsulClient) decryptValue(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if c.keyring == nil {
+		return "", fmt.Errorf("setting is encrypted but no secret keyring is configured")
+	}
+	return c.keyring.Decrypt(value)
+}
+
+func (k *SecretKeyring) unwrap(value string) (version string, dataKey []byte, ciphertext []byte, err error) {
+	if !IsEncrypted(value) {
+		return "", nil, nil, fmt.Errorf("value is not encrypted")
+	}
+	parts := strings.Split(strings.TrimPrefix(value, EncryptedPrefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, fmt.Errorf("malformed encrypted value")
+	}
+	version = parts[0]
+	kek, ok := k.keys[version]
+	if !ok {
+		return "", nil, nil, fmt.Errorf("unknown key version %q", version)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if ciphertext, err = base64.StdEncoding.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, err
+	}
+	if dataKey, err = openSealed(kek, wrapped, []byte(EncryptedPrefix+version)); err != nil {
+		return "", nil, nil, fmt.Errorf("unwrap data key (key version %s): %w", version, err)
+	}
+	return version, dataKey, ciphertext, nil
+}
+
+// sealGCM encrypts with AES-GCM and prepends the random nonce
+func sealGCM(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func openSealed(key []byte, sealed []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additionalData)
+}
@@ -0,0 +1,260 @@
+package fxconsul
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const snapshotEncryptedPrefix = "fxsnap:v1:"
+
+// persistedSnapshot is the on-disk format of the last-known-good KV snapshot
+type persistedSnapshot struct {
+	BasePath string            `json:"basePath"`
+	Index    uint64            `json:"index"`
+	SavedAt  time.Time         `json:"savedAt"`
+	Values   map[string]string `json:"values"`
+}
+
+// snapshotStore persists KV snapshots to a local file and serves them while Consul is unreachable
+type snapshotStore struct {
+	path string
+	key  []byte // AES key (16, 24 or 32 bytes); nil stores the snapshot in plain JSON
+
+	mu      sync.RWMutex
+	values  map[string]string // Last known values, served whenever Consul is down
+	savedAt time.Time
+}
+
+// ConsulStatus reports the connection state and the age of the local snapshot
+type ConsulStatus struct {
+	Available       bool          `json:"available"`
+	State           string        `json:"state"`
+	ServingSnapshot bool          `json:"servingSnapshot"`
+	SnapshotSavedAt *time.Time    `json:"snapshotSavedAt,omitempty"`
+	SnapshotAge     time.Duration `json:"snapshotAge"`
+	LastIndex       uint64        `json:"lastIndex"`
+}
+
+// WithSnapshotFile persists the last-known-good KV snapshot to path after every successful load,
+// and serves it when Consul is unreachable, at startup or after a later outage
+func WithSnapshotFile(path string) Option {
+	return func(o *clientOptions) {
+		o.snapshotFile = path
+	}
+}
+
+// WithSnapshotEncryptionKey encrypts the snapshot file with AES-GCM (key of 16, 24 or 32 bytes)
+func WithSnapshotEncryptionKey(key []byte) Option {
+	return func(o *clientOptions) {
+		o.snapshotKey = key
+	}
+}
+
+// Status returns the connection state, including whether values come from the local snapshot
+func (c *ConsulClient) Status() ConsulStatus {
+	status := ConsulStatus{Available: c.IsAvailable(), State: c.State().String(), LastIndex: c.lastIndex.Load()}
+	if c.snapshots != nil {
+		c.snapshots.mu.RLock()
+		status.ServingSnapshot = c.snapshots.values != nil && !status.Available
+		savedAt := c.snapshots.savedAt
+		c.snapshots.mu.RUnlock()
+		if !savedAt.IsZero() {
+			status.SnapshotSavedAt = &savedAt
+			status.SnapshotAge = time.Since(savedAt)
+		}
+	}
+	return status
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+func newSnapshotStore(path string, key []byte) (*snapshotStore, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if key != nil {
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("invalid snapshot encryption key: %w", err)
+		}
+	}
+	return &snapshotStore{path: path, key: key}, nil
+}
+
+// primeSnapshot loads the full KV tree once Consul is reachable and persists it
+func (c *ConsulClient) primeSnapshot() {
+	if c.snapshots == nil || !c.IsAvailable() {
+		return
+	}
+	pairs, index, err := c.listLayers(context.Background(), 0)
+	if err != nil {
+		c.logger.Printf("Warning: Failed to load Consul snapshot: %v", err)
+		return
+	}
+	c.saveSnapshot(c.snapshotFromPairs(pairs), index)
+}
+
+// restoreSnapshot loads the local snapshot when Consul is unavailable at startup.
+// The restored values seed the watcher so that reconnecting reports what changed while offline.
+func (c *ConsulClient) restoreSnapshot() {
+	if c.snapshots == nil {
+		return
+	}
+	snapshot, err := c.snapshots.read()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			c.logger.Printf("Warning: Failed to read Consul snapshot %s: %v", c.snapshots.path, err)
+		}
+		return
+	}
+	if snapshot.BasePath != c.basePath {
+		c.logger.Printf("Warning: Ignoring Consul snapshot of %s, client uses %s", snapshot.BasePath, c.basePath)
+		return
+	}
+	c.snapshots.mu.Lock()
+	c.snapshots.values = snapshot.Values
+	c.snapshots.savedAt = snapshot.SavedAt
+	c.snapshots.mu.Unlock()
+
+	c.snapshot = make(map[string]kvEntry, len(snapshot.Values))
+	for key, value := range snapshot.Values {
+		c.snapshot[key] = kvEntry{value: value}
+	}
+	c.logger.Printf("Serving %d settings from Consul snapshot saved %s ago", len(snapshot.Values), time.Since(snapshot.SavedAt).Round(time.Second))
+}
+
+// lookupSnapshot serves a key from the local snapshot while Consul is unavailable
+func (c *ConsulClient) lookupSnapshot(key string) (string, bool) {
+	if c.snapshots == nil || c.IsAvailable() {
+		return "", false
+	}
+	c.snapshots.mu.RLock()
+	defer c.snapshots.mu.RUnlock()
+	value, ok := c.snapshots.values[key]
+	return value, ok && value != ""
+}
+
+// saveSnapshot keeps the values observed from Consul for the next outage and persists them
+func (c *ConsulClient) saveSnapshot(values map[string]kvEntry, index uint64) {
+	if c.snapshots == nil {
+		return
+	}
+	snapshot := persistedSnapshot{
+		BasePath: c.basePath,
+		Index:    index,
+		SavedAt:  time.Now(),
+		Values:   make(map[string]string, len(values)),
+	}
+	for key, entry := range values {
+		snapshot.Values[key] = entry.value
+	}
+	c.snapshots.mu.Lock()
+	c.snapshots.values = snapshot.Values
+	c.snapshots.savedAt = snapshot.SavedAt
+	c.snapshots.mu.Unlock()
+	if err := c.snapshots.write(snapshot); err != nil {
+		c.logger.Printf("Warning: Failed to write Consul snapshot %s: %v", c.snapshots.path, err)
+	}
+}
+
+// write stores the snapshot atomically: a temp file in the same directory is renamed over the target
+func (s *snapshotStore) write(snapshot persistedSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if s.key != nil {
+		if data, err = s.encrypt(data); err != nil {
+			return err
+		}
+	}
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *snapshotStore) read() (persistedSnapshot, error) {
+	var snapshot persistedSnapshot
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return snapshot, err
+	}
+	if strings.HasPrefix(string(data), snapshotEncryptedPrefix) {
+		if s.key == nil {
+			return snapshot, fmt.Errorf("snapshot is encrypted but no encryption key is configured")
+		}
+		if data, err = s.decrypt(data); err != nil {
+			return snapshot, err
+		}
+	}
+	err = json.Unmarshal(data, &snapshot)
+	return snapshot, err
+}
+
+func (s *snapshotStore) encrypt(plain []byte) ([]byte, error) {
+	gcm, err := newGCM(s.key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nonce, nonce, plain, []byte(snapshotEncryptedPrefix))
+	return []byte(snapshotEncryptedPrefix + base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+func (s *snapshotStore) decrypt(data []byte) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(string(data), snapshotEncryptedPrefix))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(s.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("snapshot ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, []byte(snapshotEncryptedPrefix))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
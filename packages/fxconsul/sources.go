@@ -0,0 +1,432 @@
+package fxconsul
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// Source is a single layer of configuration values keyed like Consul keys ("db/maxConns")
+type Source interface {
+	Name() string
+	Lookup(key string) (string, bool)
+	Keys() []string
+}
+
+// DefaultSecretPattern matches keys whose values are redacted in provenance reports
+var DefaultSecretPattern = regexp.MustCompile(`(?i)(password|passwd|secret|token|credential|private|api[_-]?key)`)
+
+// LayeredConfig resolves keys across sources in precedence order (first source wins)
+type LayeredConfig struct {
+	sources       []Source
+	mu            sync.RWMutex
+	SecretPattern *regexp.Regexp
+}
+
+// ProvenanceEntry reports which source supplied the effective value of a key
+type ProvenanceEntry struct {
+	Key      string   `json:"key"`
+	Value    string   `json:"value"`
+	Source   string   `json:"source"`
+	Shadowed []string `json:"shadowed,omitempty"` // Lower precedence sources that also define the key
+	Secret   bool     `json:"secret"`
+}
+
+// NewLayeredConfig creates a configuration whose sources are given from highest to lowest precedence
+func NewLayeredConfig(sources ...Source) *LayeredConfig {
+	return &LayeredConfig{sources: sources, SecretPattern: DefaultSecretPattern}
+}
+
+// DefaultSources returns the default GetSetting order: Consul KV, then environment variables
+func DefaultSources(client *ConsulClient) []Source {
+	return []Source{ConsulSource(client), EnvSource("")}
+}
+
+// Sources returns the layered configuration GetSetting and the typed getters resolve keys through:
+// sources added to it or reordered with SetPrecedence apply to every read of the client
+func (c *ConsulClient) Sources() *LayeredConfig {
+	return c.sources
+}
+
+// AddSource appends a source with the lowest precedence
+func (l *LayeredConfig) AddSource(source Source) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sources = append(l.sources, source)
+}
+
+// SetPrecedence reorders the sources by name, highest precedence first.
+// Sources not named keep their relative order after the named ones.
+func (l *LayeredConfig) SetPrecedence(names ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rank := make(map[string]int, len(names))
+	for i, name := range names {
+		rank[name] = i
+	}
+	sort.SliceStable(l.sources, func(i, j int) bool {
+		ri, okI := rank[l.sources[i].Name()]
+		rj, okJ := rank[l.sources[j].Name()]
+		switch {
+		case okI && okJ:
+			return ri < rj
+		default:
+			return okI && !okJ
+		}
+	})
+}
+
+// Lookup returns the effective value of a key and the name of the source that supplied it
+func (l *LayeredConfig) Lookup(key string) (value string, source string, found bool) {
+	return l.lookupContext(context.Background(), key)
+}
+
+// Get returns the effective value of a key or the default value
+func (l *LayeredConfig) Get(key string, defaultValue string) string {
+	if value, _, ok := l.Lookup(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// Provenance reports every known key with its effective value and source. Secret values are redacted.
+func (l *LayeredConfig) Provenance() []ProvenanceEntry {
+	sources := l.currentSources()
+	keys := make(map[string]bool)
+	for _, s := range sources {
+		for _, key := range s.Keys() {
+			keys[key] = true
+		}
+	}
+	// An environment variable already reported under the setting key it configures is not listed again
+	for _, s := range sources {
+		if env, ok := s.(*envSource); ok && env.prefix != "" {
+			for key := range keys {
+				if name := EnvKey(env.prefix, key); name != key {
+					delete(keys, name)
+				}
+			}
+		}
+	}
+	result := make([]ProvenanceEntry, 0, len(keys))
+	for key := range keys {
+		entry := ProvenanceEntry{Key: key, Secret: l.isSecret(key)}
+		for _, s := range sources {
+			value, ok := s.Lookup(key)
+			if !ok {
+				continue
+			}
+			if entry.Source == "" {
+				entry.Source = s.Name()
+				entry.Value = value
+			} else {
+				entry.Shadowed = append(entry.Shadowed, s.Name())
+			}
+		}
+		if entry.Source == "" {
+			continue
+		}
+		if entry.Secret {
+			entry.Value = redactedValue
+		}
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// ProvenanceHandler serves the provenance report as JSON for debugging
+func (l *LayeredConfig) ProvenanceHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		sources := l.currentSources()
+		names := make([]string, 0, len(sources))
+		for _, s := range sources {
+			names = append(names, s.Name())
+		}
+		ctx.JSON(http.StatusOK, gin.H{"precedence": names, "settings": l.Provenance()})
+	}
+}
+
+func (l *LayeredConfig) isSecret(key string) bool {
+	return l.SecretPattern != nil && l.SecretPattern.MatchString(key)
+}
+
+// currentSources returns a copy of the sources in precedence order. Sources are queried on the copy,
+// so a slow Consul lookup does not hold the lock that AddSource and SetPrecedence wait for.
+func (l *LayeredConfig) currentSources() []Source {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return slices.Clone(l.sources)
+}
+
+// lookupContext resolves a key like Lookup, bounding Consul lookups by ctx
+func (l *LayeredConfig) lookupContext(ctx context.Context, key string) (string, string, bool) {
+	for _, s := range l.currentSources() {
+		var value string
+		var ok bool
+		if consul, isConsul := s.(*consulSource); isConsul {
+			value, ok = consul.client.lookupConsul(ctx, key)
+		} else {
+			value, ok = s.Lookup(key)
+		}
+		if ok {
+			return value, s.Name(), true
+		}
+	}
+	return "", "", false
+}
+
+// ----------------------------------------------------------------------------------------
+// Sources
+// ----------------------------------------------------------------------------------------
+
+type consulSource struct {
+	client *ConsulClient
+}
+
+// ConsulSource reads keys from the Consul KV store under the client's basePath (through its cache)
+func ConsulSource(client *ConsulClient) Source {
+	return &consulSource{client: client}
+}
+
+func (s *consulSource) Name() string { return "consul" }
+
+func (s *consulSource) Lookup(key string) (string, bool) {
+	return s.client.lookupConsul(context.Background(), key)
+}
+
+func (s *consulSource) Keys() []string {
+	c := s.client
+	if c.allowRequest() {
+		if settings, err := c.EffectiveSettings(); err == nil {
+			result := make([]string, 0, len(settings))
+			for key := range settings {
+				result = append(result, key)
+			}
+			return result
+		}
+	}
+	c.cacheMu.RLock()
+	defer c.cacheMu.RUnlock()
+	result := make([]string, 0, len(c.cache))
+	for key, entry := range c.cache {
+		if entry.found {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+type envSource struct {
+	prefix string
+}
+
+// EnvSource reads environment variables. A key is looked up verbatim ("db/maxConns") and then, when a
+// prefix is given, in its conventional form with the prefix prepended (prefix "APP_" gives "APP_DB_MAXCONNS").
+// Keys reports the variables under their own names.
+func EnvSource(prefix string) Source {
+	return &envSource{prefix: prefix}
+}
+
+func (s *envSource) Name() string { return "env" }
+
+func (s *envSource) Lookup(key string) (string, bool) {
+	if value := os.Getenv(key); value != "" {
+		return value, true
+	}
+	if s.prefix == "" {
+		return "", false
+	}
+	if value := os.Getenv(EnvKey(s.prefix, key)); value != "" {
+		return value, true
+	}
+	return "", false
+}
+
+func (s *envSource) Keys() []string {
+	result := make([]string, 0)
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		if (s.prefix != "" && strings.HasPrefix(name, s.prefix)) || strings.Contains(name, "/") {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// EnvKey converts a setting key to its environment variable form: "db/maxConns" -> PREFIX + "DB_MAXCONNS"
+func EnvKey(prefix string, key string) string {
+	replacer := strings.NewReplacer("/", "_", ".", "_", "-", "_")
+	return prefix + strings.ToUpper(replacer.Replace(key))
+}
+
+// MapSource holds in-memory values, typically used for overrides and tests
+type MapSource struct {
+	name   string
+	values map[string]string
+	mu     sync.RWMutex
+}
+
+// NewMapSource creates an in-memory source
+func NewMapSource(name string, values map[string]string) *MapSource {
+	copied := make(map[string]string, len(values))
+	for k, v := range values {
+		copied[k] = v
+	}
+	return &MapSource{name: name, values: copied}
+}
+
+func (s *MapSource) Name() string { return s.name }
+
+func (s *MapSource) Lookup(key string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.values[key]
+	return value, ok
+}
+
+func (s *MapSource) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]string, 0, len(s.values))
+	for key := range s.values {
+		result = append(result, key)
+	}
+	return result
+}
+
+// Set overrides a key
+func (s *MapSource) Set(key string, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+}
+
+// Delete removes an override
+func (s *MapSource) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+}
+
+// DotEnvSource loads KEY=VALUE lines from a .env file. Keys are matched verbatim and in EnvKey form.
+func DotEnvSource(path string) (Source, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, lineNumber)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &dotEnvSource{MapSource: NewMapSource("dotenv:"+filepath.Base(path), values)}, nil
+}
+
+type dotEnvSource struct {
+	*MapSource
+}
+
+func (s *dotEnvSource) Lookup(key string) (string, bool) {
+	if value, ok := s.MapSource.Lookup(key); ok {
+		return value, true
+	}
+	return s.MapSource.Lookup(EnvKey("", key))
+}
+
+// FileSource loads a YAML or JSON document (by extension) and flattens nested objects into "a/b" keys
+func FileSource(path string) (Source, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var document map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &document)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &document)
+	default:
+		return nil, fmt.Errorf("unsupported configuration file %s, expected .json, .yaml or .yml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	values := make(map[string]string)
+	flattenDocument("", document, values)
+	return NewMapSource("file:"+filepath.Base(path), values), nil
+}
+
+func flattenDocument(prefix string, node map[string]any, values map[string]string) {
+	for key, value := range node {
+		fullKey := prefix + key
+		switch v := value.(type) {
+		case map[string]any:
+			flattenDocument(fullKey+"/", v, values)
+		default:
+			values[fullKey] = jsonScalarString(v)
+		}
+	}
+}
+
+type flagSource struct {
+	flags *flag.FlagSet
+}
+
+// FlagSource exposes command-line flags that were explicitly set; "db.maxConns" maps to "db/maxConns"
+func FlagSource(flags *flag.FlagSet) Source {
+	return &flagSource{flags: flags}
+}
+
+func (s *flagSource) Name() string { return "flags" }
+
+func (s *flagSource) Lookup(key string) (string, bool) {
+	var value string
+	found := false
+	s.flags.Visit(func(f *flag.Flag) {
+		if f.Name == key || strings.ReplaceAll(f.Name, ".", "/") == key {
+			value, found = f.Value.String(), true
+		}
+	})
+	return value, found
+}
+
+func (s *flagSource) Keys() []string {
+	result := make([]string, 0)
+	s.flags.Visit(func(f *flag.Flag) {
+		result = append(result, strings.ReplaceAll(f.Name, ".", "/"))
+	})
+	return result
+}
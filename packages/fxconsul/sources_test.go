@@ -0,0 +1,63 @@
+package fxconsul_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tacjlee/common-sdk/packages/fxconsul"
+	"github.com/tacjlee/common-sdk/packages/fxconsul/consultest"
+)
+
+func TestSourcePrecedenceAndProvenance(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.SetAll(map[string]string{
+		"config/dev/settings/db/host":     "consul-db",
+		"config/dev/settings/db/password": "hunter2",
+	})
+	client := newClient(t, server)
+	overrides := fxconsul.NewMapSource("overrides", map[string]string{"db/host": "local-db"})
+	sources := client.Sources()
+	sources.AddSource(overrides)
+
+	if got := client.GetSetting("db/host", ""); got != "consul-db" {
+		t.Errorf("GetSetting(db/host) = %q, want consul-db from the highest source", got)
+	}
+	sources.SetPrecedence("overrides")
+	if value, source, _ := sources.Lookup("db/host"); value != "local-db" || source != "overrides" {
+		t.Errorf("Lookup(db/host) = %q from %s, want local-db from overrides", value, source)
+	}
+
+	for _, entry := range sources.Provenance() {
+		switch entry.Key {
+		case "db/host":
+			if entry.Source != "overrides" || len(entry.Shadowed) != 1 {
+				t.Errorf("db/host provenance = %+v, want overrides shadowing consul", entry)
+			}
+		case "db/password":
+			if !entry.Secret || entry.Value == "hunter2" {
+				t.Errorf("db/password provenance = %+v, want a redacted secret", entry)
+			}
+		}
+	}
+}
+
+func TestSlowConsulLookupDoesNotBlockAddSource(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.Set("config/dev/settings/db/host", "consul-db")
+	client := newClient(t, server)
+	server.SetLatency(500 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.GetSettingContext(context.Background(), "db/host", "")
+	}()
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	client.Sources().AddSource(fxconsul.NewMapSource("overrides", nil))
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("AddSource waited %s for a Consul lookup", elapsed)
+	}
+	<-done
+}
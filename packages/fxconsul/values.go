@@ -0,0 +1,150 @@
+package fxconsul
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SettingError reports a setting whose value cannot be parsed as the requested type
+type SettingError struct {
+	Key   string
+	Value string
+	Type  string
+	Err   error
+}
+
+func (e *SettingError) Error() string {
+	return fmt.Sprintf("setting %s: cannot parse %q as %s: %v", e.Key, e.Value, e.Type, e.Err)
+}
+
+func (e *SettingError) Unwrap() error {
+	return e.Err
+}
+
+// WithStrictSettings makes NewConsulClient fail when the configuration does not match the schema (WithSchema).
+// Without a schema there is nothing to validate and strict mode has no effect.
+// Typed getters never fail: call ValidateSettings once startup configuration has been read to fail fast
+// on invalid values.
+func WithStrictSettings(strict bool) Option {
+	return func(o *clientOptions) {
+		o.strict = strict
+	}
+}
+
+// ValidateSettings returns an error listing the settings that do not match the schema and the invalid values
+// met by typed getters so far, or nil. Call it at startup, after reading the configuration.
+func (c *ConsulClient) ValidateSettings() error {
+	var errs []error
+	if c.schema != nil {
+		if report := c.ValidateSchema(); !report.OK() {
+			errs = append(errs, fmt.Errorf("configuration does not match the schema: %d missing, %d invalid",
+				len(report.Missing), len(report.Invalid)))
+		}
+	}
+	settingErrors := c.SettingErrors()
+	keys := make([]string, 0, len(settingErrors))
+	for key := range settingErrors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		errs = append(errs, settingErrors[key])
+	}
+	return errors.Join(errs...)
+}
+
+// SettingErrors returns the invalid values met by typed getters since the client was created, keyed by setting
+func (c *ConsulClient) SettingErrors() map[string]*SettingError {
+	c.settingErrorsMu.Lock()
+	defer c.settingErrorsMu.Unlock()
+	result := make(map[string]*SettingError, len(c.settingErrors))
+	for key, err := range c.settingErrors {
+		result[key] = err
+	}
+	return result
+}
+
+// GetSettingFloat retrieves a floating point configuration value
+func (c *ConsulClient) GetSettingFloat(key string, defaultValue float64) float64 {
+	return getTyped(c, key, defaultValue, "float", func(raw string) (float64, error) {
+		return strconv.ParseFloat(raw, 64)
+	})
+}
+
+// GetSettingDuration retrieves a duration such as "1m30s"; a plain integer is read as seconds
+func (c *ConsulClient) GetSettingDuration(key string, defaultValue time.Duration) time.Duration {
+	return getTyped(c, key, defaultValue, "duration", parseDuration)
+}
+
+// GetSettingStrings retrieves a list given as a JSON array or a comma separated value
+func (c *ConsulClient) GetSettingStrings(key string, defaultValue []string) []string {
+	return getTyped(c, key, defaultValue, "list", splitList)
+}
+
+// GetSettingMap retrieves a map given as a JSON object or comma separated key=value pairs
+func (c *ConsulClient) GetSettingMap(key string, defaultValue map[string]string) map[string]string {
+	return getTyped(c, key, defaultValue, "map", splitMap)
+}
+
+// GetSettingJSON decodes a JSON document stored in a setting into T
+func GetSettingJSON[T any](c *ConsulClient, key string, defaultValue T) T {
+	return getTyped(c, key, defaultValue, fmt.Sprintf("JSON %T", defaultValue), func(raw string) (T, error) {
+		var value T
+		err := json.Unmarshal([]byte(raw), &value)
+		return value, err
+	})
+}
+
+// GetSettingYAML decodes a YAML document stored in a setting into T
+func GetSettingYAML[T any](c *ConsulClient, key string, defaultValue T) T {
+	return getTyped(c, key, defaultValue, fmt.Sprintf("YAML %T", defaultValue), func(raw string) (T, error) {
+		var value T
+		err := yaml.Unmarshal([]byte(raw), &value)
+		return value, err
+	})
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+
+// getTyped reads and parses a setting; an empty value yields the default, an invalid one is reported.
+// The setting is read with an empty default, so a schema default takes precedence over defaultValue.
+func getTyped[T any](c *ConsulClient, key string, defaultValue T, typeName string, parse func(raw string) (T, error)) T {
+	raw := strings.TrimSpace(c.GetSetting(key, ""))
+	if raw == "" {
+		return defaultValue
+	}
+	value, err := parse(raw)
+	if err != nil {
+		c.reportSettingError(&SettingError{Key: key, Value: raw, Type: typeName, Err: err})
+		return defaultValue
+	}
+	c.clearSettingError(key)
+	return value
+}
+
+// reportSettingError logs and records the error, reported by ValidateSettings
+func (c *ConsulClient) reportSettingError(err *SettingError) {
+	c.settingErrorsMu.Lock()
+	_, known := c.settingErrors[err.Key]
+	c.settingErrors[err.Key] = err
+	c.settingErrorsMu.Unlock()
+
+	if !known {
+		c.logger.Printf("Warning: Invalid %v - using default value", err)
+	}
+}
+
+func (c *ConsulClient) clearSettingError(key string) {
+	c.settingErrorsMu.Lock()
+	delete(c.settingErrors, key)
+	c.settingErrorsMu.Unlock()
+}
@@ -0,0 +1,275 @@
+package fxdatabase
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tacjlee/common-sdk/packages/fxconsul"
+	"gorm.io/gorm"
+)
+
+// DatabaseConfig holds the connection and pool settings of a database
+type DatabaseConfig struct {
+	Name             string        // Logical name used as the metrics label
+	DSN              string        // Driver specific data source name
+	MaxOpenConns     int           // 0 means unlimited
+	MaxIdleConns     int           // Idle connections kept in the pool
+	ConnMaxLifetime  time.Duration // 0 means connections are reused forever
+	ConnMaxIdleTime  time.Duration // 0 means idle connections are not closed due to idle time
+	StatementTimeout time.Duration // Deadline applied to gorm statements other than Row/Rows, 0 disables it
+	PingTimeout      time.Duration // Deadline of the health check ping
+}
+
+// DefaultDatabaseConfig returns the pool settings used when nothing is configured
+func DefaultDatabaseConfig() DatabaseConfig {
+	return DatabaseConfig{
+		Name:            "default",
+		MaxOpenConns:    25,
+		MaxIdleConns:    10,
+		ConnMaxLifetime: 30 * time.Minute,
+		ConnMaxIdleTime: 5 * time.Minute,
+		PingTimeout:     2 * time.Second,
+	}
+}
+
+// ConfigFromConsul reads the database settings under prefix (e.g. "db") from Consul,
+// falling back to the given defaults. Keys: dsn, maxOpenConns, maxIdleConns,
+// connMaxLifetime, connMaxIdleTime, statementTimeout, pingTimeout (durations such as "30s").
+func ConfigFromConsul(client *fxconsul.ConsulClient, prefix string, defaults DatabaseConfig) DatabaseConfig {
+	key := func(name string) string {
+		if prefix == "" {
+			return name
+		}
+		return strings.TrimSuffix(prefix, "/") + "/" + name
+	}
+	duration := func(name string, defaultValue time.Duration) time.Duration {
+		value := client.GetSetting(key(name), "")
+		if value == "" {
+			return defaultValue
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			log.Printf("Warning: Invalid duration %q for %s: %v", value, key(name), err)
+			return defaultValue
+		}
+		return parsed
+	}
+	config := defaults
+	config.DSN = client.GetSetting(key("dsn"), defaults.DSN)
+	config.MaxOpenConns = client.GetSettingInt(key("maxOpenConns"), defaults.MaxOpenConns)
+	config.MaxIdleConns = client.GetSettingInt(key("maxIdleConns"), defaults.MaxIdleConns)
+	config.ConnMaxLifetime = duration("connMaxLifetime", defaults.ConnMaxLifetime)
+	config.ConnMaxIdleTime = duration("connMaxIdleTime", defaults.ConnMaxIdleTime)
+	config.StatementTimeout = duration("statementTimeout", defaults.StatementTimeout)
+	config.PingTimeout = duration("pingTimeout", defaults.PingTimeout)
+	return config
+}
+
+// Database wraps a gorm connection together with its pool configuration
+type Database struct {
+	DB *gorm.DB
+
+	sqlDB    *sql.DB
+	config   DatabaseConfig
+	configMu sync.RWMutex
+}
+
+// Open opens a gorm connection using the dialector constructor of the driver (e.g. postgres.Open)
+// and applies the pool settings of the config
+func Open(config DatabaseConfig, dialector func(dsn string) gorm.Dialector, gormConfig ...gorm.Option) (*Database, error) {
+	if config.DSN == "" {
+		return nil, fmt.Errorf("database %s: DSN is required", config.Name)
+	}
+	db, err := gorm.Open(dialector(config.DSN), gormConfig...)
+	if err != nil {
+		return nil, fmt.Errorf("database %s: open: %w", config.Name, err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("database %s: %w", config.Name, err)
+	}
+	database := &Database{DB: db, sqlDB: sqlDB}
+	database.ApplyPoolConfig(config)
+	if err := database.registerStatementTimeout(); err != nil {
+		_ = sqlDB.Close()
+		return nil, err
+	}
+	if err := database.HealthCheck(context.Background()); err != nil {
+		_ = sqlDB.Close()
+		return nil, err
+	}
+	return database, nil
+}
+
+// Config returns the currently applied configuration
+func (d *Database) Config() DatabaseConfig {
+	d.configMu.RLock()
+	defer d.configMu.RUnlock()
+	return d.config
+}
+
+// ApplyPoolConfig resizes the pool of a running connection. The DSN cannot be changed on the fly.
+func (d *Database) ApplyPoolConfig(config DatabaseConfig) {
+	d.configMu.Lock()
+	defer d.configMu.Unlock()
+	d.sqlDB.SetMaxOpenConns(config.MaxOpenConns)
+	d.sqlDB.SetMaxIdleConns(config.MaxIdleConns)
+	d.sqlDB.SetConnMaxLifetime(config.ConnMaxLifetime)
+	d.sqlDB.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+	if d.config.DSN != "" && config.DSN != d.config.DSN {
+		log.Printf("Warning: database %s DSN change requires a restart, keeping the current connection", config.Name)
+		config.DSN = d.config.DSN
+	}
+	d.config = config
+}
+
+// WatchConsul resizes the pool whenever the Consul settings under prefix change
+func (d *Database) WatchConsul(client *fxconsul.ConsulClient, prefix string) {
+	client.OnConfigChange(func(changedKeys []string) {
+		for _, key := range changedKeys {
+			if prefix == "" || strings.HasPrefix(key, strings.TrimSuffix(prefix, "/")+"/") {
+				config := ConfigFromConsul(client, prefix, d.Config())
+				d.ApplyPoolConfig(config)
+				log.Printf("Database %s pool resized: maxOpen=%d maxIdle=%d", config.Name, config.MaxOpenConns, config.MaxIdleConns)
+				return
+			}
+		}
+	})
+}
+
+// HealthCheck pings the database within the configured ping timeout
+func (d *Database) HealthCheck(ctx context.Context) error {
+	timeout := d.Config().PingTimeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := d.sqlDB.PingContext(ctx); err != nil {
+		return fmt.Errorf("database %s: ping: %w", d.Config().Name, err)
+	}
+	return nil
+}
+
+// PoolStats is a snapshot of the connection pool state
+type PoolStats struct {
+	Name              string        `json:"name"`
+	MaxOpenConns      int           `json:"maxOpenConns"`
+	OpenConnections   int           `json:"openConnections"`
+	InUse             int           `json:"inUse"`
+	Idle              int           `json:"idle"`
+	WaitCount         int64         `json:"waitCount"`
+	WaitDuration      time.Duration `json:"waitDuration"`
+	MaxIdleClosed     int64         `json:"maxIdleClosed"`
+	MaxIdleTimeClosed int64         `json:"maxIdleTimeClosed"`
+	MaxLifetimeClosed int64         `json:"maxLifetimeClosed"`
+}
+
+// Stats returns the current pool statistics
+func (d *Database) Stats() PoolStats {
+	stats := d.sqlDB.Stats()
+	return PoolStats{
+		Name:              d.Config().Name,
+		MaxOpenConns:      stats.MaxOpenConnections,
+		OpenConnections:   stats.OpenConnections,
+		InUse:             stats.InUse,
+		Idle:              stats.Idle,
+		WaitCount:         stats.WaitCount,
+		WaitDuration:      stats.WaitDuration,
+		MaxIdleClosed:     stats.MaxIdleClosed,
+		MaxIdleTimeClosed: stats.MaxIdleTimeClosed,
+		MaxLifetimeClosed: stats.MaxLifetimeClosed,
+	}
+}
+
+// HealthHandler responds 200 when the database answers a ping and 503 otherwise
+func (d *Database) HealthHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if err := d.HealthCheck(ctx.Request.Context()); err != nil {
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{"status": "DOWN", "error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{"status": "UP", "pool": d.Stats()})
+	}
+}
+
+// MetricsHandler exports the pool statistics in the Prometheus text exposition format
+func (d *Database) MetricsHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		stats := d.Stats()
+		label := fmt.Sprintf("{database=%q}", stats.Name)
+		var sb strings.Builder
+		writeMetric := func(name string, kind string, help string, value any) {
+			fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s %s\n%s%s %v\n", name, help, name, kind, name, label, value)
+		}
+		writeMetric("db_pool_max_open_connections", "gauge", "Maximum number of open connections.", stats.MaxOpenConns)
+		writeMetric("db_pool_open_connections", "gauge", "Number of established connections.", stats.OpenConnections)
+		writeMetric("db_pool_in_use_connections", "gauge", "Number of connections currently in use.", stats.InUse)
+		writeMetric("db_pool_idle_connections", "gauge", "Number of idle connections.", stats.Idle)
+		writeMetric("db_pool_wait_count_total", "counter", "Total number of connections waited for.", stats.WaitCount)
+		writeMetric("db_pool_wait_duration_seconds_total", "counter", "Total time blocked waiting for a connection.", stats.WaitDuration.Seconds())
+		writeMetric("db_pool_max_idle_closed_total", "counter", "Connections closed due to SetMaxIdleConns.", stats.MaxIdleClosed)
+		writeMetric("db_pool_max_idle_time_closed_total", "counter", "Connections closed due to SetConnMaxIdleTime.", stats.MaxIdleTimeClosed)
+		writeMetric("db_pool_max_lifetime_closed_total", "counter", "Connections closed due to SetConnMaxLifetime.", stats.MaxLifetimeClosed)
+		ctx.Data(http.StatusOK, "text/plain; version=0.0.4", []byte(sb.String()))
+	}
+}
+
+// Close closes the underlying connection pool
+func (d *Database) Close() error {
+	return d.sqlDB.Close()
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+const cancelKey = "fxdatabase:cancel"
+
+// registerStatementTimeout bounds the gorm statements by the configured statement timeout
+func (d *Database) registerStatementTimeout() error {
+	before := func(db *gorm.DB) {
+		timeout := d.Config().StatementTimeout
+		if timeout <= 0 {
+			return
+		}
+		if _, hasDeadline := db.Statement.Context.Deadline(); hasDeadline {
+			return
+		}
+		ctx, cancel := context.WithTimeout(db.Statement.Context, timeout)
+		db.Statement.Context = ctx
+		db.InstanceSet(cancelKey, cancel)
+	}
+	after := func(db *gorm.DB) {
+		if cancel, ok := db.InstanceGet(cancelKey); ok {
+			cancel.(context.CancelFunc)()
+		}
+	}
+	// Row and Rows statements are not bounded: their rows are read after the callback chain, and gorm
+	// gives no hook to release the context when the rows are closed. They follow the caller's context.
+	callback := d.DB.Callback()
+	registrations := []error{
+		callback.Create().Before("gorm:create").Register("fxdatabase:timeout_before_create", before),
+		callback.Create().After("gorm:create").Register("fxdatabase:timeout_after_create", after),
+		callback.Query().Before("gorm:query").Register("fxdatabase:timeout_before_query", before),
+		callback.Query().After("gorm:query").Register("fxdatabase:timeout_after_query", after),
+		callback.Update().Before("gorm:update").Register("fxdatabase:timeout_before_update", before),
+		callback.Update().After("gorm:update").Register("fxdatabase:timeout_after_update", after),
+		callback.Delete().Before("gorm:delete").Register("fxdatabase:timeout_before_delete", before),
+		callback.Delete().After("gorm:delete").Register("fxdatabase:timeout_after_delete", after),
+		callback.Raw().Before("gorm:raw").Register("fxdatabase:timeout_before_raw", before),
+		callback.Raw().After("gorm:raw").Register("fxdatabase:timeout_after_raw", after),
+	}
+	for _, err := range registrations {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package fxdatabase
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils/tests"
+)
+
+// recordingDB is a database/sql connector whose queries return one row and record the context they ran with
+type recordingDB struct {
+	mu       sync.Mutex
+	contexts []context.Context
+}
+
+func (r *recordingDB) Connect(context.Context) (driver.Conn, error) {
+	return &recordingConn{db: r}, nil
+}
+
+func (r *recordingDB) Driver() driver.Driver {
+	return recordingDriver{db: r}
+}
+
+// last returns the context of the latest query
+func (r *recordingDB) last() context.Context {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.contexts[len(r.contexts)-1]
+}
+
+// dialector opens the connector with the dummy gorm dialect
+func (r *recordingDB) dialector(dsn string) gorm.Dialector {
+	return recordingDialector{db: r}
+}
+
+type recordingDialector struct {
+	tests.DummyDialector
+	db *recordingDB
+}
+
+func (d recordingDialector) Initialize(db *gorm.DB) error {
+	db.ConnPool = sql.OpenDB(d.db)
+	return d.DummyDialector.Initialize(db)
+}
+
+type recordingDriver struct{ db *recordingDB }
+
+func (d recordingDriver) Open(string) (driver.Conn, error) {
+	return &recordingConn{db: d.db}, nil
+}
+
+type recordingConn struct{ db *recordingDB }
+
+func (c *recordingConn) Prepare(string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepared statements are not supported")
+}
+
+func (c *recordingConn) Close() error {
+	return nil
+}
+
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions are not supported")
+}
+
+func (c *recordingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.contexts = append(c.db.contexts, ctx)
+	return &recordingRows{}, nil
+}
+
+type recordingRows struct{ done bool }
+
+func (r *recordingRows) Columns() []string {
+	return []string{"id"}
+}
+
+func (r *recordingRows) Close() error {
+	return nil
+}
+
+func (r *recordingRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(1)
+	return nil
+}
+
+func openRecording(t *testing.T, timeout time.Duration) (*Database, *recordingDB) {
+	t.Helper()
+	recorder := &recordingDB{}
+	config := DefaultDatabaseConfig()
+	config.DSN = "recording"
+	config.StatementTimeout = timeout
+	database, err := Open(config, recorder.dialector, &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+	return database, recorder
+}
+
+func TestStatementTimeoutIsReleasedAfterQuery(t *testing.T) {
+	database, recorder := openRecording(t, time.Minute)
+
+	var accounts []map[string]any
+	if err := database.DB.Table("accounts").Find(&accounts).Error; err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	ctx := recorder.last()
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		t.Fatal("the query ran without the statement timeout")
+	}
+	if ctx.Err() == nil {
+		t.Error("the statement context is still running after the query returned")
+	}
+}
+
+func TestRowsOutliveTheStatementTimeout(t *testing.T) {
+	database, recorder := openRecording(t, 20*time.Millisecond)
+
+	rows, err := database.DB.Raw("SELECT id FROM accounts").Rows()
+	if err != nil {
+		t.Fatalf("Rows: %v", err)
+	}
+	defer rows.Close()
+	if _, hasDeadline := recorder.last().Deadline(); hasDeadline {
+		t.Error("Rows holds a statement timeout that nothing releases once the rows are closed")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if !rows.Next() {
+		t.Fatalf("a read slower than the statement timeout was aborted: %v", rows.Err())
+	}
+}
@@ -0,0 +1,467 @@
+package fxflags
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tacjlee/common-sdk/packages/fxconsul"
+	"github.com/tacjlee/common-sdk/packages/fxcontext"
+)
+
+// Flag is a feature flag definition stored as JSON under the flag prefix in Consul KV, e.g. key "flags/new-checkout":
+//
+//	{
+//	  "enabled": true,
+//	  "variations": {"on": true, "off": false},
+//	  "offVariation": "off",
+//	  "defaultVariation": "off",
+//	  "rules": [{"conditions": [{"attribute": "tenantId", "operator": "in", "values": ["acme"]}], "variation": "on"}],
+//	  "rollout": {"bucketBy": "userId", "variations": [{"variation": "on", "weight": 10}, {"variation": "off", "weight": 90}]}
+//	}
+type Flag struct {
+	Key              string         `json:"key"`
+	Enabled          bool           `json:"enabled"` // Kill switch: a disabled flag always serves OffVariation
+	Variations       map[string]any `json:"variations"`
+	OffVariation     string         `json:"offVariation"`
+	DefaultVariation string         `json:"defaultVariation"`
+	Rules            []Rule         `json:"rules"`
+	Rollout          *Rollout       `json:"rollout"` // Applied when no rule matches
+	Salt             string         `json:"salt"`    // Changes bucketing without renaming the flag
+}
+
+// Rule serves a variation (or a rollout) when all its conditions match
+type Rule struct {
+	Conditions []Condition `json:"conditions"`
+	Variation  string      `json:"variation"`
+	Rollout    *Rollout    `json:"rollout"`
+}
+
+// Condition compares an attribute of the evaluation context.
+// Operators: in (the default), notIn, startsWith, endsWith, contains.
+type Condition struct {
+	Attribute string   `json:"attribute"`
+	Operator  string   `json:"operator"`
+	Values    []string `json:"values"`
+}
+
+// Rollout splits traffic between variations by percentage. Assignment is sticky: the same
+// BucketBy attribute value always lands in the same bucket for a given flag. A context without the
+// BucketBy attribute is served the default variation.
+type Rollout struct {
+	BucketBy   string              `json:"bucketBy"` // Defaults to "userId"
+	Variations []WeightedVariation `json:"variations"`
+}
+
+// WeightedVariation is a rollout share in percent (weights should add up to 100)
+type WeightedVariation struct {
+	Variation string  `json:"variation"`
+	Weight    float64 `json:"weight"`
+}
+
+// EvaluationContext holds the attributes flags are evaluated against
+type EvaluationContext struct {
+	UserID     string
+	TenantID   string
+	Roles      []string
+	Attributes map[string]string
+}
+
+// Evaluation is the result of evaluating a flag
+type Evaluation struct {
+	Key       string `json:"key"`
+	Value     any    `json:"value"`
+	Variation string `json:"variation"`
+	Reason    string `json:"reason"` // off, rule:<index>, rollout, default, flag-not-found, error
+}
+
+// ContextFromGin builds the evaluation context from the identity set on the gin context by the auth middleware
+func ContextFromGin(ctx *gin.Context) EvaluationContext {
+	ec := EvaluationContext{
+		UserID:     fxcontext.GetUserID(ctx),
+		TenantID:   fxcontext.GetTenantID(ctx),
+		Attributes: map[string]string{},
+	}
+	if role := fxcontext.GetRole(ctx); role != "" {
+		ec.Roles = []string{role}
+	}
+	if email := fxcontext.GetEmail(ctx); email != "" {
+		ec.Attributes["email"] = email
+	}
+	if orgUnitID := fxcontext.GetOrgUnitID(ctx); orgUnitID != "" {
+		ec.Attributes["orgUnitId"] = orgUnitID
+	}
+	return ec
+}
+
+// ErrConsulUnavailable is returned by Reload when Consul cannot be reached; the loaded flags are kept
+var ErrConsulUnavailable = errors.New("consul is unavailable, keeping the loaded feature flags")
+
+// Client evaluates flags loaded from Consul KV and reloads them when the prefix changes
+type Client struct {
+	consul      *fxconsul.ConsulClient
+	prefix      string
+	flags       atomic.Pointer[map[string]*Flag]
+	mu          sync.Mutex // Serializes updates of flags
+	index       uint64     // Consul index of the last update, older change sets are ignored
+	closed      atomic.Bool
+	unsubscribe func()
+}
+
+// NewClient loads every flag under prefix (relative to the configuration layers) and subscribes to changes.
+// Invalid definitions are skipped with a warning. When Consul is unavailable the client starts without flags
+// and loads them once it reconnects. Hot reload requires the Consul client to be watching (WatchConfig).
+func NewClient(consul *fxconsul.ConsulClient, prefix string) (*Client, error) {
+	c := &Client{consul: consul, prefix: strings.TrimSuffix(prefix, "/") + "/"}
+	empty := map[string]*Flag{}
+	c.flags.Store(&empty)
+	if err := c.Reload(); err != nil && !errors.Is(err, ErrConsulUnavailable) {
+		if c.flags.Load() == &empty {
+			return nil, err // Nothing was loaded
+		}
+		log.Printf("Warning: %v", err)
+	}
+	c.unsubscribe = consul.OnKeyChange(c.prefix, c.applyChanges)
+	consul.OnStateChange(func(old fxconsul.ConnectionState, new fxconsul.ConnectionState) {
+		if new == fxconsul.StateConnected && !c.closed.Load() {
+			go c.reloadAfterReconnect()
+		}
+	})
+	return c, nil
+}
+
+// Reload fetches every flag definition from Consul, merged across the configuration layers.
+// Invalid definitions are skipped and reported. When Consul is unavailable the loaded flags are kept
+// and ErrConsulUnavailable is returned.
+func (c *Client) Reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.consul.IsAvailable() {
+		return ErrConsulUnavailable
+	}
+	index := c.consul.Status().LastIndex
+	flags := make(map[string]*Flag)
+	settings, err := c.consul.EffectiveSettings()
+	if err != nil {
+		return err
+	}
+	var invalid []string
+	for name, setting := range settings {
+		key, ok := strings.CutPrefix(name, c.prefix)
+		if !ok {
+			continue
+		}
+		flag, err := parseFlag(key, setting.Value)
+		if err != nil {
+			invalid = append(invalid, err.Error())
+			continue
+		}
+		flags[key] = flag
+	}
+	c.flags.Store(&flags)
+	c.index = max(c.index, index)
+	if len(invalid) > 0 {
+		return fmt.Errorf("invalid flag definitions: %s", strings.Join(invalid, "; "))
+	}
+	return nil
+}
+
+// Close stops following flag changes; the loaded flags keep being served
+func (c *Client) Close() {
+	c.closed.Store(true)
+	c.unsubscribe()
+}
+
+// Flags returns the loaded flag definitions
+func (c *Client) Flags() map[string]*Flag {
+	return *c.flags.Load()
+}
+
+// Evaluate returns the variation of the flag for the context
+func (c *Client) Evaluate(key string, ec EvaluationContext) Evaluation {
+	flag, ok := c.Flags()[key]
+	if !ok {
+		return Evaluation{Key: key, Reason: "flag-not-found"}
+	}
+	return flag.Evaluate(ec)
+}
+
+// BoolVariation returns the boolean value of the flag, or defaultValue when it is missing or not boolean
+func (c *Client) BoolVariation(key string, ec EvaluationContext, defaultValue bool) bool {
+	if value, ok := c.Evaluate(key, ec).Value.(bool); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// StringVariation returns the string value of the flag, or defaultValue
+func (c *Client) StringVariation(key string, ec EvaluationContext, defaultValue string) string {
+	if value, ok := c.Evaluate(key, ec).Value.(string); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// IsEnabled evaluates a boolean flag for the caller of a gin request
+func (c *Client) IsEnabled(ctx *gin.Context, key string) bool {
+	return c.BoolVariation(key, ContextFromGin(ctx), false)
+}
+
+// RequireFlag aborts the request with 404 unless the boolean flag is on for the caller
+func (c *Client) RequireFlag(key string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !c.IsEnabled(ctx, key) {
+			ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// EvaluateAllHandler returns every flag evaluated for the caller, for front-end bootstrapping
+func (c *Client) EvaluateAllHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ec := ContextFromGin(ctx)
+		result := make(map[string]any)
+		for key, flag := range c.Flags() {
+			result[key] = flag.Evaluate(ec).Value
+		}
+		ctx.JSON(http.StatusOK, result)
+	}
+}
+
+// Evaluate applies the kill switch, rules, rollout and default in that order
+func (f *Flag) Evaluate(ec EvaluationContext) Evaluation {
+	if !f.Enabled {
+		return f.serve(f.OffVariation, "off")
+	}
+	for i, rule := range f.Rules {
+		if !rule.matches(ec) {
+			continue
+		}
+		if rule.Rollout != nil {
+			if variation := f.bucket(rule.Rollout, ec); variation != "" {
+				return f.serve(variation, fmt.Sprintf("rule:%d", i))
+			}
+			return f.serve(f.DefaultVariation, "default")
+		}
+		return f.serve(rule.Variation, fmt.Sprintf("rule:%d", i))
+	}
+	if f.Rollout != nil {
+		if variation := f.bucket(f.Rollout, ec); variation != "" {
+			return f.serve(variation, "rollout")
+		}
+	}
+	return f.serve(f.DefaultVariation, "default")
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+func parseFlag(key string, definition string) (*Flag, error) {
+	var flag Flag
+	if err := json.Unmarshal([]byte(definition), &flag); err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	flag.Key = key
+	if len(flag.Variations) == 0 {
+		// Boolean flag shorthand
+		flag.Variations = map[string]any{"on": true, "off": false}
+		if flag.OffVariation == "" {
+			flag.OffVariation = "off"
+		}
+		if flag.DefaultVariation == "" {
+			flag.DefaultVariation = "on"
+		}
+	}
+	for _, name := range []string{flag.OffVariation, flag.DefaultVariation} {
+		if _, ok := flag.Variations[name]; !ok && name != "" {
+			return nil, fmt.Errorf("%s: unknown variation %q", key, name)
+		}
+	}
+	if err := flag.Rollout.validate(flag.Variations); err != nil {
+		return nil, fmt.Errorf("%s: rollout: %w", key, err)
+	}
+	for i, rule := range flag.Rules {
+		if err := rule.validate(flag.Variations); err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %w", key, i, err)
+		}
+	}
+	return &flag, nil
+}
+
+func (r Rule) validate(variations map[string]any) error {
+	for _, condition := range r.Conditions {
+		switch condition.Operator {
+		case "", "in", "notIn", "startsWith", "endsWith", "contains":
+		default:
+			return fmt.Errorf("unknown operator %q", condition.Operator)
+		}
+	}
+	if r.Rollout != nil {
+		return r.Rollout.validate(variations)
+	}
+	if _, ok := variations[r.Variation]; !ok {
+		return fmt.Errorf("unknown variation %q", r.Variation)
+	}
+	return nil
+}
+
+func (r *Rollout) validate(variations map[string]any) error {
+	if r == nil {
+		return nil
+	}
+	for _, weighted := range r.Variations {
+		if _, ok := variations[weighted.Variation]; !ok {
+			return fmt.Errorf("unknown variation %q", weighted.Variation)
+		}
+	}
+	return nil
+}
+
+// reloadAfterReconnect reloads every flag, the changes made while Consul was unreachable not being notified
+func (c *Client) reloadAfterReconnect() {
+	if err := c.Reload(); err != nil && !errors.Is(err, ErrConsulUnavailable) {
+		log.Printf("Warning: Feature flags reloaded with errors: %v", err)
+	}
+}
+
+// applyChanges updates only the flags contained in the change set, ignoring change sets older than the last update
+func (c *Client) applyChanges(changes fxconsul.ChangeSet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if changes.Index < c.index {
+		return
+	}
+	current := c.Flags()
+	next := make(map[string]*Flag, len(current))
+	for key, flag := range current {
+		next[key] = flag
+	}
+	for _, change := range changes.Changes {
+		key := strings.TrimPrefix(change.Key, c.prefix)
+		if change.Type == fxconsul.ChangeDeleted {
+			delete(next, key)
+			continue
+		}
+		flag, err := parseFlag(key, change.NewValue)
+		if err != nil {
+			// Keep the previous definition rather than serving a broken one
+			log.Printf("Warning: Ignoring invalid feature flag update: %v", err)
+			continue
+		}
+		next[key] = flag
+	}
+	c.flags.Store(&next)
+	c.index = changes.Index
+}
+
+func (f *Flag) serve(variation string, reason string) Evaluation {
+	value, ok := f.Variations[variation]
+	if !ok {
+		return Evaluation{Key: f.Key, Reason: "error"}
+	}
+	return Evaluation{Key: f.Key, Value: value, Variation: variation, Reason: reason}
+}
+
+// bucket assigns the context to a rollout variation using a stable hash of flag key, salt and bucketing attribute
+func (f *Flag) bucket(rollout *Rollout, ec EvaluationContext) string {
+	bucketBy := rollout.BucketBy
+	if bucketBy == "" {
+		bucketBy = "userId"
+	}
+	values := ec.attribute(bucketBy)
+	if len(values) == 0 || len(rollout.Variations) == 0 {
+		return ""
+	}
+	sum := sha1.Sum([]byte(f.Key + "." + f.Salt + "." + values[0]))
+	point := float64(binary.BigEndian.Uint32(sum[:4])) / float64(1<<32) * 100
+	cumulative := 0.0
+	for _, weighted := range rollout.Variations {
+		cumulative += weighted.Weight
+		if point < cumulative {
+			return weighted.Variation
+		}
+	}
+	return rollout.Variations[len(rollout.Variations)-1].Variation
+}
+
+func (r Rule) matches(ec EvaluationContext) bool {
+	for _, condition := range r.Conditions {
+		if !condition.matches(ec.attribute(condition.Attribute)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Condition) matches(actual []string) bool {
+	if c.Operator == "notIn" {
+		for _, value := range actual {
+			if contains(c.Values, value) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, value := range actual {
+		for _, expected := range c.Values {
+			switch c.Operator {
+			case "startsWith":
+				if strings.HasPrefix(value, expected) {
+					return true
+				}
+			case "endsWith":
+				if strings.HasSuffix(value, expected) {
+					return true
+				}
+			case "contains":
+				if strings.Contains(value, expected) {
+					return true
+				}
+			default: // in, rejected operators never reach evaluation
+				if value == expected {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (ec EvaluationContext) attribute(name string) []string {
+	switch name {
+	case "userId":
+		if ec.UserID != "" {
+			return []string{ec.UserID}
+		}
+	case "tenantId":
+		if ec.TenantID != "" {
+			return []string{ec.TenantID}
+		}
+	case "role", "roles":
+		return ec.Roles
+	default:
+		if value, ok := ec.Attributes[name]; ok {
+			return []string{value}
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package fxflags
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tacjlee/common-sdk/packages/fxconsul"
+	"github.com/tacjlee/common-sdk/packages/fxconsul/consultest"
+)
+
+func mustParse(t *testing.T, key string, definition string) *Flag {
+	t.Helper()
+	flag, err := parseFlag(key, definition)
+	if err != nil {
+		t.Fatalf("parseFlag(%s): %v", key, err)
+	}
+	return flag
+}
+
+func TestParseFlag(t *testing.T) {
+	flag := mustParse(t, "dark-mode", `{"enabled": true}`)
+	if flag.Key != "dark-mode" || flag.OffVariation != "off" || flag.DefaultVariation != "on" || flag.Variations["on"] != true {
+		t.Errorf("boolean shorthand = %+v, want on/off variations serving on by default", flag)
+	}
+
+	invalid := map[string]string{
+		"malformed":             `{"enabled": `,
+		"unknown default":       `{"variations": {"a": 1}, "defaultVariation": "b"}`,
+		"unknown rule":          `{"rules": [{"variation": "maybe"}]}`,
+		"unknown operator":      `{"rules": [{"conditions": [{"attribute": "userId", "operator": "like"}], "variation": "on"}]}`,
+		"unknown rollout share": `{"rollout": {"variations": [{"variation": "maybe", "weight": 100}]}}`,
+		"unknown rule rollout":  `{"rules": [{"rollout": {"variations": [{"variation": "maybe", "weight": 100}]}}]}`,
+	}
+	for name, definition := range invalid {
+		if _, err := parseFlag("flag", definition); err == nil {
+			t.Errorf("%s: the definition %s was accepted", name, definition)
+		}
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	flag := mustParse(t, "new-checkout", `{
+		"enabled": true,
+		"variations": {"on": true, "off": false, "beta": "beta"},
+		"offVariation": "off",
+		"defaultVariation": "off",
+		"rules": [
+			{"conditions": [{"attribute": "tenantId", "values": ["acme"]}, {"attribute": "roles", "operator": "notIn", "values": ["guest"]}], "variation": "on"},
+			{"conditions": [{"attribute": "email", "operator": "endsWith", "values": ["@example.com"]}], "variation": "beta"}
+		]
+	}`)
+	cases := []struct {
+		name      string
+		ec        EvaluationContext
+		variation string
+		reason    string
+	}{
+		{"first rule", EvaluationContext{TenantID: "acme", Roles: []string{"admin"}}, "on", "rule:0"},
+		{"excluded role", EvaluationContext{TenantID: "acme", Roles: []string{"guest"}}, "off", "default"},
+		{"second rule", EvaluationContext{Attributes: map[string]string{"email": "ann@example.com"}}, "beta", "rule:1"},
+		{"no match", EvaluationContext{TenantID: "globex"}, "off", "default"},
+	}
+	for _, tc := range cases {
+		if got := flag.Evaluate(tc.ec); got.Variation != tc.variation || got.Reason != tc.reason {
+			t.Errorf("%s: Evaluate = %s (%s), want %s (%s)", tc.name, got.Variation, got.Reason, tc.variation, tc.reason)
+		}
+	}
+
+	flag.Enabled = false
+	if got := flag.Evaluate(EvaluationContext{TenantID: "acme"}); got.Value != false || got.Reason != "off" {
+		t.Errorf("disabled flag = %+v, want the off variation", got)
+	}
+}
+
+func TestRolloutBucketing(t *testing.T) {
+	flag := mustParse(t, "new-search", `{
+		"enabled": true,
+		"defaultVariation": "off",
+		"rollout": {"variations": [{"variation": "on", "weight": 10}, {"variation": "off", "weight": 90}]}
+	}`)
+	const users = 10000
+	on := 0
+	for i := range users {
+		ec := EvaluationContext{UserID: fmt.Sprintf("user-%d", i)}
+		first := flag.Evaluate(ec)
+		if again := flag.Evaluate(ec); again.Variation != first.Variation {
+			t.Fatalf("user-%d moved from %s to %s", i, first.Variation, again.Variation)
+		}
+		if first.Reason != "rollout" {
+			t.Fatalf("user-%d was served by %s, want the rollout", i, first.Reason)
+		}
+		if first.Variation == "on" {
+			on++
+		}
+	}
+	if share := float64(on) / users * 100; math.Abs(share-10) > 1.5 {
+		t.Errorf("%.1f%% of the users are in the 10%% bucket", share)
+	}
+
+	if got := flag.Evaluate(EvaluationContext{}); got.Reason != "default" {
+		t.Errorf("a context without userId was served by %s, want the default", got.Reason)
+	}
+
+	salted := *flag
+	salted.Salt = "reshuffle"
+	moved := 0
+	for i := range 1000 {
+		ec := EvaluationContext{UserID: fmt.Sprintf("user-%d", i)}
+		if salted.Evaluate(ec).Variation != flag.Evaluate(ec).Variation {
+			moved++
+		}
+	}
+	if moved == 0 {
+		t.Error("changing the salt did not change any bucket")
+	}
+}
+
+func TestReloadMergesTheConfigurationLayers(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.SetAll(map[string]string{
+		"config/billing/dev/flags/new-checkout": `{"enabled": true}`,
+		"config/shared/flags/new-checkout":      `{"enabled": false}`,
+		"config/shared/flags/dark-mode":         `{"enabled": true}`,
+		"config/shared/flags/broken":            `{"enabled": `,
+	})
+	consul := server.Client(t, fxconsul.WithLogger(log.New(io.Discard, "", 0)), fxconsul.WithLayers("config/billing/dev", "config/shared"))
+	client, err := NewClient(consul, "flags")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.Reload(); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("Reload error = %v, want the broken definition reported", err)
+	}
+	flags := client.Flags()
+	if len(flags) != 2 || flags["dark-mode"] == nil {
+		t.Fatalf("Reload loaded %v, want the flags of every layer", flags)
+	}
+	if !flags["new-checkout"].Enabled {
+		t.Error("the shared definition of new-checkout shadows the service one")
+	}
+}
+
+func TestCloseStopsFollowingChanges(t *testing.T) {
+	server := consultest.NewServer(t)
+	server.Set("config/shared/flags/dark-mode", `{"enabled": true}`)
+	consul := server.Client(t, fxconsul.WithLogger(log.New(io.Discard, "", 0)), fxconsul.WithLayers("config/shared"))
+	client, err := NewClient(consul, "flags")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	consul.WatchConfig()
+	if !server.WaitForBlockingQueries(1, 5*time.Second) {
+		t.Fatal("the watch did not start a blocking query")
+	}
+
+	server.Set("config/shared/flags/dark-mode", `{"enabled": false}`)
+	deadline := time.Now().Add(5 * time.Second)
+	for client.Flags()["dark-mode"].Enabled {
+		if time.Now().After(deadline) {
+			t.Fatal("the flag update was not applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client.Close()
+	server.Set("config/shared/flags/dark-mode", `{"enabled": true}`)
+	time.Sleep(200 * time.Millisecond)
+	if client.Flags()["dark-mode"].Enabled {
+		t.Error("a change was applied after Close")
+	}
+}
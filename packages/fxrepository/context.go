@@ -0,0 +1,101 @@
+package fxrepository
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tacjlee/common-sdk/packages/fxcontext"
+)
+
+// contextKey is the type of the context keys set by this package, so they cannot collide with other packages
+type contextKey string
+
+const (
+	rolesContextKey     contextKey = "roles"
+	actorContextKey     contextKey = "actor"
+	tenantIDContextKey  contextKey = "tenantID"
+	requestIDContextKey contextKey = "requestID"
+)
+
+// ContextWithRoles returns a copy of ctx carrying the caller's roles, read by RolesFromContext
+func ContextWithRoles(ctx context.Context, roles ...string) context.Context {
+	return context.WithValue(ctx, rolesContextKey, roles)
+}
+
+// RolesFromContext returns the roles set with ContextWithRoles or, for a gin context, the role set by
+// the authentication middleware
+func RolesFromContext(ctx context.Context) []string {
+	if ctx == nil {
+		return nil
+	}
+	if roles, ok := ctx.Value(rolesContextKey).([]string); ok {
+		return roles
+	}
+	if ginCtx, ok := ctx.(*gin.Context); ok {
+		if role := fxcontext.GetRole(ginCtx); role != "" {
+			return []string{role}
+		}
+	}
+	return nil
+}
+
+// ContextWithActor returns a copy of ctx carrying the user recorded in entity history
+func ContextWithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorContextKey, actor)
+}
+
+// ContextWithTenantID returns a copy of ctx carrying the tenant recorded in entity history
+func ContextWithTenantID(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantIDContextKey, tenantID)
+}
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID recorded in entity history
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, requestID)
+}
+
+// ActorFromContext returns the actor set with ContextWithActor or, for a gin context, the username
+// (or user ID) set by the authentication middleware
+func ActorFromContext(ctx context.Context) string {
+	if actor := contextString(ctx, actorContextKey); actor != "" {
+		return actor
+	}
+	if ginCtx, ok := ctx.(*gin.Context); ok {
+		if username := fxcontext.GetUsername(ginCtx); username != "" {
+			return username
+		}
+		return fxcontext.GetUserID(ginCtx)
+	}
+	return ""
+}
+
+// TenantIDFromContext returns the tenant set with ContextWithTenantID or by the authentication middleware
+func TenantIDFromContext(ctx context.Context) string {
+	if tenantID := contextString(ctx, tenantIDContextKey); tenantID != "" {
+		return tenantID
+	}
+	if ginCtx, ok := ctx.(*gin.Context); ok {
+		return fxcontext.GetTenantID(ginCtx)
+	}
+	return ""
+}
+
+// RequestIDFromContext returns the request ID set with ContextWithRequestID or, for a gin context,
+// the X-Request-ID header
+func RequestIDFromContext(ctx context.Context) string {
+	if requestID := contextString(ctx, requestIDContextKey); requestID != "" {
+		return requestID
+	}
+	if ginCtx, ok := ctx.(*gin.Context); ok && ginCtx.Request != nil {
+		return ginCtx.GetHeader("X-Request-ID")
+	}
+	return ""
+}
+
+func contextString(ctx context.Context, key contextKey) string {
+	if ctx == nil {
+		return ""
+	}
+	value, _ := ctx.Value(key).(string)
+	return value
+}
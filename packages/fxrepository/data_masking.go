@@ -0,0 +1,231 @@
+package fxrepository
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tacjlee/common-sdk/packages/fxstring"
+)
+
+// MaskStrategy defines how a sensitive column value is transformed
+type MaskStrategy string
+
+const (
+	MaskRedact   MaskStrategy = "redact"   // Replace the whole value with the redaction text
+	MaskPartial  MaskStrategy = "partial"  // Keep the first/last characters and mask the rest
+	MaskHash     MaskStrategy = "hash"     // Replace with a (salted) SHA-256 hex digest
+	MaskTokenize MaskStrategy = "tokenize" // Replace with a stable token produced by the policy tokenizer
+)
+
+// MaskingRule matches columns by name pattern and/or database type and applies a strategy.
+// Callers holding any of UnmaskRoles receive the original value.
+type MaskingRule struct {
+	ColumnPattern string   // Case-insensitive regular expression matched against the column name
+	ColumnTypes   []string // Database type names (e.g. "VARCHAR", "TEXT"); empty matches any type
+	Strategy      MaskStrategy
+	KeepFirst     int // Characters kept at the start for MaskPartial
+	KeepLast      int // Characters kept at the end for MaskPartial
+	UnmaskRoles   []string
+
+	pattern *regexp.Regexp
+}
+
+// MaskingAuditEvent describes a query that returned unmasked sensitive columns
+type MaskingAuditEvent struct {
+	Query     string
+	Columns   []string
+	Roles     []string
+	RowCount  int
+	Timestamp time.Time
+}
+
+// MaskingPolicy is the set of masking rules applied by the repository row mappers
+type MaskingPolicy struct {
+	Rules         []MaskingRule
+	RedactionText string
+	MaskChar      rune
+	HashSalt      string
+	Tokenizer     func(column string, value string) string
+	RoleResolver  func(ctx context.Context) []string
+	AuditHook     func(ctx context.Context, event MaskingAuditEvent)
+}
+
+// NewMaskingPolicy validates the rule patterns and returns a policy with default settings
+func NewMaskingPolicy(rules ...MaskingRule) (*MaskingPolicy, error) {
+	policy := &MaskingPolicy{
+		RedactionText: "******",
+		MaskChar:      '*',
+		RoleResolver:  RolesFromContext,
+	}
+	for _, rule := range rules {
+		if err := policy.AddRule(rule); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// AddRule compiles the column pattern of the rule and appends it to the policy
+func (p *MaskingPolicy) AddRule(rule MaskingRule) error {
+	if rule.ColumnPattern != "" {
+		pattern, err := regexp.Compile("(?i)" + rule.ColumnPattern)
+		if err != nil {
+			return err
+		}
+		rule.pattern = pattern
+	}
+	p.Rules = append(p.Rules, rule)
+	return nil
+}
+
+func (rule *MaskingRule) matches(column *sql.ColumnType) bool {
+	if rule.pattern != nil && !rule.pattern.MatchString(column.Name()) {
+		return false
+	}
+	if len(rule.ColumnTypes) > 0 {
+		typeName := strings.ToUpper(column.DatabaseTypeName())
+		for _, t := range rule.ColumnTypes {
+			if strings.ToUpper(t) == typeName {
+				return true
+			}
+		}
+		return false
+	}
+	return rule.pattern != nil
+}
+
+func (rule *MaskingRule) allows(roles []string) bool {
+	for _, allowed := range rule.UnmaskRoles {
+		for _, role := range roles {
+			if strings.EqualFold(allowed, role) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *MaskingPolicy) maskValue(rule *MaskingRule, column string, value string) string {
+	switch rule.Strategy {
+	case MaskPartial:
+		runes := []rune(value)
+		keepFirst, keepLast := max(rule.KeepFirst, 0), max(rule.KeepLast, 0)
+		if keepFirst+keepLast >= len(runes) {
+			return strings.Repeat(string(p.MaskChar), len(runes))
+		}
+		masked := strings.Repeat(string(p.MaskChar), len(runes)-keepFirst-keepLast)
+		return string(runes[:keepFirst]) + masked + string(runes[len(runes)-keepLast:])
+	case MaskHash:
+		return p.digest(value)
+	case MaskTokenize:
+		if p.Tokenizer != nil {
+			return p.Tokenizer(column, value)
+		}
+		return "tok_" + p.digest(column + ":" + value)[:24]
+	default:
+		return p.RedactionText
+	}
+}
+
+func (p *MaskingPolicy) digest(value string) string {
+	if p.HashSalt == "" {
+		sum := sha256.Sum256([]byte(value))
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, []byte(p.HashSalt))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// rowMasker holds the per-query resolution of masking rules to result columns.
+// A nil rowMasker is valid and leaves rows untouched.
+type rowMasker struct {
+	ctx      context.Context
+	policy   *MaskingPolicy
+	query    string
+	roles    []string
+	rules    []*MaskingRule // Indexed by column position, nil when the column is not sensitive
+	names    []string
+	unmasked []string
+}
+
+func (this *genericRepository) newRowMasker(query string, rows *sql.Rows) (*rowMasker, error) {
+	if this.masking == nil || len(this.masking.Rules) == 0 {
+		return nil, nil
+	}
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	var roles []string
+	if this.masking.RoleResolver != nil {
+		roles = this.masking.RoleResolver(this.ctx)
+	}
+	masker := &rowMasker{
+		ctx:    this.ctx,
+		policy: this.masking,
+		query:  query,
+		roles:  roles,
+		rules:  make([]*MaskingRule, len(columnTypes)),
+		names:  make([]string, len(columnTypes)),
+	}
+	sensitive := false
+	for i, column := range columnTypes {
+		masker.names[i] = column.Name()
+		for r := range this.masking.Rules {
+			rule := &this.masking.Rules[r]
+			if !rule.matches(column) {
+				continue
+			}
+			if rule.allows(roles) {
+				masker.unmasked = append(masker.unmasked, column.Name())
+			} else {
+				masker.rules[i] = rule
+				sensitive = true
+			}
+			break
+		}
+	}
+	if !sensitive && len(masker.unmasked) == 0 {
+		return nil, nil
+	}
+	return masker, nil
+}
+
+func (m *rowMasker) apply(columnsData []any) {
+	if m == nil {
+		return
+	}
+	for i, rule := range m.rules {
+		if rule == nil || columnsData[i] == nil {
+			continue
+		}
+		var value string
+		if b, ok := columnsData[i].([]byte); ok {
+			value = string(b)
+		} else {
+			value = fxstring.ToString(columnsData[i])
+		}
+		columnsData[i] = m.policy.maskValue(rule, m.names[i], value)
+	}
+}
+
+// audit reports sensitive columns that were returned unmasked because of the caller's roles
+func (m *rowMasker) audit(rowCount int) {
+	if m == nil || len(m.unmasked) == 0 || rowCount == 0 || m.policy.AuditHook == nil {
+		return
+	}
+	m.policy.AuditHook(m.ctx, MaskingAuditEvent{
+		Query:     m.query,
+		Columns:   m.unmasked,
+		Roles:     m.roles,
+		RowCount:  rowCount,
+		Timestamp: time.Now(),
+	})
+}
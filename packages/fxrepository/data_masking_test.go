@@ -0,0 +1,108 @@
+package fxrepository
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+)
+
+func TestMaskValueStrategies(t *testing.T) {
+	policy, err := NewMaskingPolicy()
+	if err != nil {
+		t.Fatalf("NewMaskingPolicy: %v", err)
+	}
+	tests := []struct {
+		rule  MaskingRule
+		value string
+		want  string
+	}{
+		{MaskingRule{Strategy: MaskRedact}, "secret", "******"},
+		{MaskingRule{Strategy: MaskPartial, KeepFirst: 2, KeepLast: 2}, "4111111111111111", "41************11"},
+		{MaskingRule{Strategy: MaskPartial, KeepFirst: 3, KeepLast: 3}, "short", "*****"},
+		{MaskingRule{Strategy: MaskPartial, KeepFirst: -1, KeepLast: 1}, "héllo", "****o"},
+	}
+	for _, test := range tests {
+		if got := policy.maskValue(&test.rule, "column", test.value); got != test.want {
+			t.Errorf("%s mask of %q = %q, want %q", test.rule.Strategy, test.value, got, test.want)
+		}
+	}
+
+	hash := MaskingRule{Strategy: MaskHash}
+	unsalted := policy.maskValue(&hash, "email", "a@example.com")
+	policy.HashSalt = "pepper"
+	if salted := policy.maskValue(&hash, "email", "a@example.com"); salted == unsalted || len(salted) != 64 {
+		t.Errorf("salted hash %q is not a different SHA-256 digest than %q", salted, unsalted)
+	}
+	token := MaskingRule{Strategy: MaskTokenize}
+	if first, second := policy.maskValue(&token, "email", "a@example.com"), policy.maskValue(&token, "email", "a@example.com"); first != second {
+		t.Errorf("tokens %q and %q of the same value differ", first, second)
+	}
+}
+
+func TestExecuteJsonListMasksUnlessRoleAllows(t *testing.T) {
+	fake := newFakeDB()
+	const query = "SELECT name, email FROM users"
+	fake.answer(query, []fakeColumn{{"name", "VARCHAR"}, {"email", "VARCHAR"}},
+		[]driver.Value{"Alice", []byte("alice@example.com")},
+		[]driver.Value{"Bob", nil},
+	)
+	var audited []MaskingAuditEvent
+	policy, err := NewMaskingPolicy(MaskingRule{ColumnPattern: "^email$", Strategy: MaskPartial, KeepFirst: 1, UnmaskRoles: []string{"admin"}})
+	if err != nil {
+		t.Fatalf("NewMaskingPolicy: %v", err)
+	}
+	policy.AuditHook = func(ctx context.Context, event MaskingAuditEvent) {
+		audited = append(audited, event)
+	}
+	repository := NewGenericRepository(fake.open(t), WithMaskingPolicy(policy))
+
+	masked, err := WithContext(repository, ContextWithRoles(context.Background(), "support")).ExecuteJsonList(query)
+	if err != nil {
+		t.Fatalf("ExecuteJsonList: %v", err)
+	}
+	want := []map[string]any{{"name": "Alice", "email": "a****************"}, {"name": "Bob", "email": nil}}
+	if !reflect.DeepEqual(masked, want) {
+		t.Errorf("masked rows = %v, want %v", masked, want)
+	}
+	if len(audited) != 0 {
+		t.Errorf("masked rows were audited: %+v", audited)
+	}
+
+	unmasked, err := WithContext(repository, ContextWithRoles(context.Background(), "Admin")).ExecuteJsonList(query)
+	if err != nil {
+		t.Fatalf("ExecuteJsonList: %v", err)
+	}
+	if email := unmasked[0]["email"]; email != "alice@example.com" {
+		t.Errorf("email for an admin = %v, want the original value", email)
+	}
+	if len(audited) != 1 || !reflect.DeepEqual(audited[0].Columns, []string{"email"}) || audited[0].RowCount != 2 {
+		t.Errorf("audit events = %+v, want one event for the unmasked email column of 2 rows", audited)
+	}
+}
+
+func TestScalarConversionsReadUnmaskedValues(t *testing.T) {
+	fake := newFakeDB()
+	const balance = "SELECT balance FROM accounts WHERE id = 1"
+	const active = "SELECT balance_visible FROM accounts WHERE id = 1"
+	fake.answer(balance, []fakeColumn{{"balance", "BIGINT"}}, []driver.Value{int64(1200)})
+	fake.answer(active, []fakeColumn{{"balance_visible", "BOOLEAN"}}, []driver.Value{[]byte("true")})
+	policy, err := NewMaskingPolicy(MaskingRule{ColumnPattern: "^balance", Strategy: MaskRedact})
+	if err != nil {
+		t.Fatalf("NewMaskingPolicy: %v", err)
+	}
+	repository := NewGenericRepository(fake.open(t), WithMaskingPolicy(policy))
+
+	if value, err := repository.ExecuteScalarAsString(balance); err != nil || value != "******" {
+		t.Errorf("ExecuteScalarAsString = %q, %v, want the redacted value", value, err)
+	}
+	if value, err := repository.ExecuteScalar(balance); err != nil || value != "******" {
+		t.Errorf("ExecuteScalar = %v, %v, want the redacted value", value, err)
+	}
+	if value, err := repository.ExecuteScalarAsLong(balance); err != nil || value != 1200 {
+		t.Errorf("ExecuteScalarAsLong = %d, %v, want 1200", value, err)
+	}
+	if value, err := repository.ExecuteScalarAsBool(active); err != nil || !value {
+		t.Errorf("ExecuteScalarAsBool = %t, %v, want true", value, err)
+	}
+}
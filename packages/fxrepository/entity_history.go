@@ -0,0 +1,407 @@
+package fxrepository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tacjlee/common-sdk/packages/fxmodel"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// HistoryOperation is the kind of change captured in an entity history record
+type HistoryOperation string
+
+const (
+	HistoryCreate HistoryOperation = "create"
+	HistoryUpdate HistoryOperation = "update"
+	HistoryDelete HistoryOperation = "delete"
+)
+
+// EntityHistory is a single captured change of a tracked entity.
+// Before/After hold JSON snapshots of the row keyed by column name, Changes holds the field-level diff.
+type EntityHistory struct {
+	ID         uint64           `gorm:"primaryKey;autoIncrement" json:"id"`
+	EntityType string           `gorm:"size:128;index:idx_entity_history_entity" json:"entityType"`
+	EntityID   string           `gorm:"size:128;index:idx_entity_history_entity" json:"entityId"`
+	Operation  HistoryOperation `gorm:"size:16" json:"operation"`
+	Before     string           `gorm:"type:text" json:"before"`
+	After      string           `gorm:"type:text" json:"after"`
+	Changes    string           `gorm:"type:text" json:"changes"`
+	Actor      string           `gorm:"size:128" json:"actor"`
+	TenantID   string           `gorm:"size:128;index" json:"tenantId"`
+	RequestID  string           `gorm:"size:128" json:"requestId"`
+	ChangedAt  time.Time        `gorm:"index" json:"changedAt"`
+}
+
+// FieldChange is the old and new value of a single column
+type FieldChange struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+// HistoryRecorder captures before/after snapshots of registered models into a history table
+type HistoryRecorder struct {
+	db         *gorm.DB
+	tableName  string
+	registered map[string]bool
+	mu         sync.RWMutex
+
+	ActorResolver     func(ctx context.Context) string
+	TenantResolver    func(ctx context.Context) string
+	RequestIDResolver func(ctx context.Context) string
+}
+
+// NewHistoryRecorder creates a recorder writing into tableName (defaults to "entity_histories")
+func NewHistoryRecorder(db *gorm.DB, tableName string) *HistoryRecorder {
+	if tableName == "" {
+		tableName = "entity_histories"
+	}
+	return &HistoryRecorder{
+		db:                db,
+		tableName:         tableName,
+		registered:        make(map[string]bool),
+		ActorResolver:     ActorFromContext,
+		TenantResolver:    TenantIDFromContext,
+		RequestIDResolver: RequestIDFromContext,
+	}
+}
+
+// WithHistory enables change tracking for the models registered in the recorder
+func WithHistory(recorder *HistoryRecorder) RepositoryOption {
+	return func(r *genericRepository) {
+		r.history = recorder
+	}
+}
+
+// AutoMigrate creates or updates the history table
+func (h *HistoryRecorder) AutoMigrate() error {
+	return h.db.Table(h.tableName).AutoMigrate(&EntityHistory{})
+}
+
+// Register opts the given models into change tracking
+func (h *HistoryRecorder) Register(models ...any) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, model := range models {
+		s, err := h.parse(model)
+		if err != nil {
+			return err
+		}
+		if s.PrioritizedPrimaryField == nil {
+			return fmt.Errorf("model %s has no primary key and cannot be tracked", s.Name)
+		}
+		h.registered[s.Table] = true
+	}
+	return nil
+}
+
+// GetHistory returns the change history of an entity ordered from oldest to newest
+func (h *HistoryRecorder) GetHistory(ctx context.Context, model any, id any) ([]EntityHistory, error) {
+	s, err := h.parse(model)
+	if err != nil {
+		return nil, err
+	}
+	var result []EntityHistory
+	err = h.db.WithContext(ctx).Table(h.tableName).
+		Where("entity_type = ? AND entity_id = ?", s.Table, fmt.Sprint(id)).
+		Order("changed_at asc, id asc").
+		Find(&result).Error
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetStateAt reconstructs the column values of an entity at the given point in time.
+// Returns nil when the entity did not exist (or was deleted) at that time.
+func (h *HistoryRecorder) GetStateAt(ctx context.Context, model any, id any, at time.Time) (map[string]any, error) {
+	s, err := h.parse(model)
+	if err != nil {
+		return nil, err
+	}
+	var records []EntityHistory
+	err = h.db.WithContext(ctx).Table(h.tableName).
+		Where("entity_type = ? AND entity_id = ? AND changed_at <= ?", s.Table, fmt.Sprint(id), at).
+		Order("changed_at desc, id desc").
+		Limit(1).
+		Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 || records[0].After == "" {
+		return nil, nil
+	}
+	var state map[string]any
+	if err := json.Unmarshal([]byte(records[0].After), &state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// ReconstructAt rebuilds a model of type T as it was at the given point in time
+func ReconstructAt[T any](ctx context.Context, h *HistoryRecorder, id any, at time.Time) (fxmodel.Optional[T], error) {
+	var result T
+	state, err := h.GetStateAt(ctx, &result, id, at)
+	if err != nil || state == nil {
+		return fxmodel.Optional[T]{Value: nil}, err
+	}
+	s, err := h.parse(&result)
+	if err != nil {
+		return fxmodel.Optional[T]{Value: nil}, err
+	}
+	target := reflect.ValueOf(&result).Elem()
+	for _, field := range s.Fields {
+		if value, ok := state[field.DBName]; ok && field.DBName != "" {
+			if err := field.Set(ctx, target, value); err != nil {
+				return fxmodel.Optional[T]{Value: nil}, fmt.Errorf("failed to restore field %s: %w", field.Name, err)
+			}
+		}
+	}
+	return fxmodel.Optional[T]{Value: &result}, nil
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+func (h *HistoryRecorder) parse(model any) (*schema.Schema, error) {
+	stmt := &gorm.Statement{DB: h.db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	return stmt.Schema, nil
+}
+
+// tracked returns the schema of the model when it is registered for change tracking
+func (h *HistoryRecorder) tracked(model any) *schema.Schema {
+	if h == nil || model == nil {
+		return nil
+	}
+	s, err := h.parse(model)
+	if err != nil {
+		return nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if !h.registered[s.Table] {
+		return nil
+	}
+	return s
+}
+
+// capture runs exec inside a transaction and records the before/after snapshots of every affected entity.
+// The keys of conditional statements are resolved before exec runs, so bulk deletes are recorded too.
+func (h *HistoryRecorder) capture(ctx context.Context, db *gorm.DB, s *schema.Schema, operation HistoryOperation,
+	model any, conditions []any, exec func(tx *gorm.DB) (int64, error)) (int64, error) {
+	var affected int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		ids, err := h.resolveIDs(ctx, tx, s, model, conditions)
+		if err != nil {
+			return err
+		}
+		befores := make([]map[string]any, len(ids))
+		if operation != HistoryCreate {
+			for i, id := range ids {
+				if befores[i], err = h.snapshot(tx, s, id); err != nil {
+					return err
+				}
+			}
+		}
+		if affected, err = exec(tx); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			// Create and Save assign the keys of new entities when the statement runs
+			if id := h.primaryKey(ctx, s, model, nil); id != nil {
+				ids = []any{id}
+			} else {
+				ids = h.modelKeys(ctx, s, model)
+			}
+			befores = make([]map[string]any, len(ids))
+		}
+		for i, id := range ids {
+			recordOperation := operation
+			var after map[string]any
+			if recordOperation != HistoryDelete {
+				if after, err = h.snapshot(tx, s, id); err != nil {
+					return err
+				}
+			}
+			if recordOperation == HistoryUpdate && befores[i] == nil {
+				recordOperation = HistoryCreate
+			}
+			if befores[i] == nil && after == nil {
+				continue
+			}
+			if err := h.write(ctx, tx, s, id, recordOperation, befores[i], after); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return affected, err
+}
+
+// resolveIDs returns the primary keys of the rows a statement affects: the key of the model, the keys of
+// a slice of models, the key(s) given as the only condition, or the keys of the rows matched by the conditions
+func (h *HistoryRecorder) resolveIDs(ctx context.Context, tx *gorm.DB, s *schema.Schema, model any, conditions []any) ([]any, error) {
+	if id := h.primaryKey(ctx, s, model, conditions); id != nil {
+		return []any{id}, nil
+	}
+	keys := h.modelKeys(ctx, s, model)
+	if len(conditions) == 0 {
+		return keys, nil
+	}
+	if len(conditions) == 1 && keys == nil {
+		if list := reflect.Indirect(reflect.ValueOf(conditions[0])); list.Kind() == reflect.Slice && list.Type().Elem().Kind() != reflect.Uint8 {
+			ids := make([]any, list.Len())
+			for i := range ids {
+				ids[i] = list.Index(i).Interface()
+			}
+			return ids, nil
+		}
+	}
+	query := tx.Table(s.Table).Where(conditions[0], conditions[1:]...)
+	if keys != nil {
+		// Like gorm, the conditions only narrow down the given models
+		query = query.Where(clause.IN{Column: clause.Column{Name: s.PrioritizedPrimaryField.DBName}, Values: keys})
+	}
+	var ids []any
+	err := query.Pluck(s.PrioritizedPrimaryField.DBName, &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve the %s rows matched by the conditions: %w", s.Table, err)
+	}
+	for i, id := range ids {
+		if b, ok := id.([]byte); ok {
+			ids[i] = string(b)
+		}
+	}
+	return ids, nil
+}
+
+// primaryKey returns the key of the model, or the single condition when it is a key value.
+// Like gorm, a string condition is a key only when it is numeric; otherwise it is SQL.
+func (h *HistoryRecorder) primaryKey(ctx context.Context, s *schema.Schema, model any, conditions []any) any {
+	value := reflect.Indirect(reflect.ValueOf(model))
+	if value.Kind() == reflect.Struct {
+		if id, zero := s.PrioritizedPrimaryField.ValueOf(ctx, value); !zero {
+			return id
+		}
+	}
+	if len(conditions) == 1 {
+		condition := reflect.Indirect(reflect.ValueOf(conditions[0]))
+		switch condition.Kind() {
+		case reflect.String:
+			if _, err := strconv.Atoi(condition.String()); err == nil {
+				return conditions[0]
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Array:
+			return conditions[0]
+		}
+	}
+	return nil
+}
+
+// modelKeys returns the non-zero keys of a slice or array of models, or nil when model is not one
+func (h *HistoryRecorder) modelKeys(ctx context.Context, s *schema.Schema, model any) []any {
+	models := reflect.Indirect(reflect.ValueOf(model))
+	if models.Kind() != reflect.Slice && models.Kind() != reflect.Array {
+		return nil
+	}
+	var keys []any
+	for i := 0; i < models.Len(); i++ {
+		element := reflect.Indirect(models.Index(i))
+		if element.Kind() != reflect.Struct {
+			return nil
+		}
+		if id, zero := s.PrioritizedPrimaryField.ValueOf(ctx, element); !zero {
+			keys = append(keys, id)
+		}
+	}
+	return keys
+}
+
+func (h *HistoryRecorder) snapshot(tx *gorm.DB, s *schema.Schema, id any) (map[string]any, error) {
+	var rows []map[string]any
+	err := tx.Table(s.Table).
+		Where(clause.Eq{Column: clause.Column{Name: s.PrioritizedPrimaryField.DBName}, Value: id}).
+		Limit(1).
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	row := rows[0]
+	for key, value := range row {
+		if b, ok := value.([]byte); ok {
+			row[key] = string(b)
+		}
+	}
+	return row, nil
+}
+
+func (h *HistoryRecorder) write(ctx context.Context, tx *gorm.DB, s *schema.Schema, id any, operation HistoryOperation,
+	before map[string]any, after map[string]any) error {
+	record := EntityHistory{
+		EntityType: s.Table,
+		EntityID:   fmt.Sprint(id),
+		Operation:  operation,
+		Actor:      h.ActorResolver(ctx),
+		TenantID:   h.TenantResolver(ctx),
+		RequestID:  h.RequestIDResolver(ctx),
+		ChangedAt:  time.Now(),
+	}
+	changes := diffSnapshots(before, after)
+	if operation == HistoryUpdate && len(changes) == 0 {
+		return nil
+	}
+	var err error
+	if record.Before, err = marshalSnapshot(before); err != nil {
+		return err
+	}
+	if record.After, err = marshalSnapshot(after); err != nil {
+		return err
+	}
+	if record.Changes, err = marshalSnapshot(changes); err != nil {
+		return err
+	}
+	return tx.Table(h.tableName).Create(&record).Error
+}
+
+func diffSnapshots(before map[string]any, after map[string]any) map[string]FieldChange {
+	keys := make(map[string]bool)
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+	changes := make(map[string]FieldChange)
+	for key := range keys {
+		oldValue, newValue := before[key], after[key]
+		if !reflect.DeepEqual(oldValue, newValue) {
+			changes[key] = FieldChange{Old: oldValue, New: newValue}
+		}
+	}
+	return changes
+}
+
+func marshalSnapshot[T any](value map[string]T) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,92 @@
+package fxrepository
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type historyAccount struct {
+	ID   int16
+	Name string
+}
+
+func newHistoryRepository(t *testing.T, fake *fakeDB) IGenericRepository {
+	t.Helper()
+	db := fake.open(t)
+	recorder := NewHistoryRecorder(db, "")
+	if err := recorder.Register(&historyAccount{}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	return NewGenericRepository(db, WithHistory(recorder))
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	before := map[string]any{"id": 1, "name": "old", "email": "a@example.com"}
+	after := map[string]any{"id": 1, "name": "new", "phone": "555"}
+	want := map[string]FieldChange{
+		"name":  {Old: "old", New: "new"},
+		"email": {Old: "a@example.com", New: nil},
+		"phone": {Old: nil, New: "555"},
+	}
+	if got := diffSnapshots(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffSnapshots = %v, want %v", got, want)
+	}
+	if got := diffSnapshots(nil, nil); len(got) != 0 {
+		t.Errorf("diffSnapshots(nil, nil) = %v, want no changes", got)
+	}
+}
+
+func TestPrimaryKeyConditions(t *testing.T) {
+	fake := newFakeDB()
+	recorder := NewHistoryRecorder(fake.open(t), "")
+	s, err := recorder.parse(&historyAccount{})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	ctx := context.Background()
+	for _, condition := range []any{int8(1), int16(2), uint8(3), uint16(4), int64(5), "6"} {
+		if got := recorder.primaryKey(ctx, s, &historyAccount{}, []any{condition}); got != condition {
+			t.Errorf("primaryKey(%T %v) = %v, want the condition", condition, condition, got)
+		}
+	}
+	if got := recorder.primaryKey(ctx, s, &historyAccount{}, []any{"name = 'x'"}); got != nil {
+		t.Errorf("primaryKey of an SQL condition = %v, want nil", got)
+	}
+	if got := recorder.primaryKey(ctx, s, &historyAccount{ID: 7}, nil); got != int16(7) {
+		t.Errorf("primaryKey of the model = %v, want 7", got)
+	}
+}
+
+func TestDeleteSliceOfModelsRecordsEachEntity(t *testing.T) {
+	fake := newFakeDB()
+	const snapshot = "SELECT * FROM `history_accounts` WHERE `id` = ? LIMIT ?"
+	columns := []fakeColumn{{"id", "SMALLINT"}, {"name", "VARCHAR"}}
+	fake.answerArgs(snapshot, []any{1, 1}, columns, []driver.Value{int64(1), "first"})
+	fake.answerArgs(snapshot, []any{2, 1}, columns, []driver.Value{int64(2), "second"})
+	repository := newHistoryRepository(t, fake)
+
+	if _, err := repository.Delete(&[]historyAccount{{ID: 1}, {ID: 2}}); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	var deleted, recorded []string
+	for _, statement := range fake.executed() {
+		switch {
+		case strings.HasPrefix(statement.query, "DELETE FROM `history_accounts`"):
+			deleted = append(deleted, statement.query)
+		case strings.HasPrefix(statement.query, "INSERT INTO `entity_histories`"):
+			if statement.args[2] != string(HistoryDelete) || !strings.Contains(statement.args[3].(string), `"name":`) {
+				t.Errorf("history record %v is not a delete with a before snapshot", statement.args)
+			}
+			recorded = append(recorded, statement.args[1].(string))
+		}
+	}
+	if len(deleted) != 1 {
+		t.Errorf("%d delete statements, want 1", len(deleted))
+	}
+	if !reflect.DeepEqual(recorded, []string{"1", "2"}) {
+		t.Errorf("history recorded for %v, want [1 2]", recorded)
+	}
+}
@@ -0,0 +1,188 @@
+package fxrepository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils/tests"
+)
+
+// fakeColumn is a result column with the database type name reported to the masking rules
+type fakeColumn struct {
+	name   string
+	dbType string
+}
+
+// fakeResult is the canned answer to one query
+type fakeResult struct {
+	columns []fakeColumn
+	rows    [][]driver.Value
+}
+
+// fakeStatement is a recorded write statement with its arguments
+type fakeStatement struct {
+	query string
+	args  []any
+}
+
+// fakeDB answers queries with canned results through database/sql, so the repository runs on gorm
+// without a database server. Other statements are recorded and affect one row.
+type fakeDB struct {
+	mu      sync.Mutex
+	results map[string]fakeResult
+	execs   []fakeStatement
+	lastID  int64
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{results: make(map[string]fakeResult)}
+}
+
+// answer registers the result of a query, matched on its exact SQL text
+func (f *fakeDB) answer(query string, columns []fakeColumn, rows ...[]driver.Value) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[query] = fakeResult{columns: columns, rows: rows}
+}
+
+// answerArgs registers the result of a query run with the given arguments; it takes precedence over
+// the answer registered for any arguments
+func (f *fakeDB) answerArgs(query string, args []any, columns []fakeColumn, rows ...[]driver.Value) {
+	f.answer(query+fmt.Sprint(args), columns, rows...)
+}
+
+// executed returns the write statements run so far
+func (f *fakeDB) executed() []fakeStatement {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeStatement(nil), f.execs...)
+}
+
+// open returns a gorm connection backed by the fake database
+func (f *fakeDB) open(t *testing.T) *gorm.DB {
+	t.Helper()
+	pool := sql.OpenDB(f)
+	t.Cleanup(func() { _ = pool.Close() })
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{ConnPool: pool, Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("gorm.Open: %v", err)
+	}
+	return db
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{db: f}, nil
+}
+
+func (f *fakeDB) Driver() driver.Driver {
+	return fakeDriver{db: f}
+}
+
+type fakeDriver struct {
+	db *fakeDB
+}
+
+func (d fakeDriver) Open(string) (driver.Conn, error) {
+	return &fakeConn{db: d.db}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("fakedb: prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	result, ok := c.db.results[query+fmt.Sprint(namedValues(args))]
+	if !ok {
+		result, ok = c.db.results[query]
+	}
+	if ok {
+		return &fakeRows{result: result}, nil
+	}
+	if strings.HasPrefix(query, "INSERT") {
+		// gorm runs INSERT ... RETURNING as a query, which returns the new key
+		c.db.execs = append(c.db.execs, fakeStatement{query: query, args: namedValues(args)})
+		c.db.lastID++
+		return &fakeRows{result: fakeResult{columns: []fakeColumn{{"id", "BIGINT"}}, rows: [][]driver.Value{{c.db.lastID}}}}, nil
+	}
+	return nil, fmt.Errorf("fakedb: unexpected query %q", query)
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.execs = append(c.db.execs, fakeStatement{query: query, args: namedValues(args)})
+	c.db.lastID++
+	return fakeExecResult{lastID: c.db.lastID}, nil
+}
+
+func namedValues(args []driver.NamedValue) []any {
+	values := make([]any, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
+
+type fakeExecResult struct {
+	lastID int64
+}
+
+func (r fakeExecResult) LastInsertId() (int64, error) { return r.lastID, nil }
+func (r fakeExecResult) RowsAffected() (int64, error) { return 1, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	result fakeResult
+	next   int
+}
+
+func (r *fakeRows) Columns() []string {
+	names := make([]string, len(r.result.columns))
+	for i, column := range r.result.columns {
+		names[i] = column.name
+	}
+	return names
+}
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(index int) string {
+	return r.result.columns[index].dbType
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.next])
+	r.next++
+	return nil
+}
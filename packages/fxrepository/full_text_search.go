@@ -0,0 +1,268 @@
+package fxrepository
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/tacjlee/common-sdk/packages/fxmodel"
+	"github.com/tacjlee/common-sdk/packages/fxstring"
+)
+
+const (
+	SearchRankAlias    = "search_rank"
+	SearchSnippetAlias = "search_snippet"
+	// RelevanceOrder sorts paging results by descending relevance
+	RelevanceOrder = SearchRankAlias + " desc"
+
+	// Markers emitted by the database snippet functions, replaced by StartSel/StopSel once the text is escaped
+	snippetStart = "\x02"
+	snippetStop  = "\x03"
+)
+
+// FullTextSearch builds dialect-appropriate full-text predicates, ranking and snippets:
+//   - PostgreSQL: to_tsvector / websearch_to_tsquery / ts_rank / ts_headline
+//     (AccentInsensitive requires the unaccent extension)
+//   - MySQL: MATCH ... AGAINST in natural language mode over a FULLTEXT index
+//     (accent-insensitivity follows the column collation, e.g. utf8mb4_0900_ai_ci)
+//   - SQLite: FTS5 virtual table MATCH / bm25 / snippet (see Fts5CreateTableSQL)
+type FullTextSearch struct {
+	Dialect           Dialect
+	Columns           []string // Searched columns; for SQLite the FTS5 table columns
+	Language          string   // PostgreSQL text search configuration, defaults to "simple"
+	FtsTable          string   // SQLite FTS5 virtual table name
+	AccentInsensitive bool     // Strip diacritics (e.g. Vietnamese) from the term and the document
+	HighlightColumn   string   // Column used to build the snippet, defaults to the first column
+	StartSel          string   // Highlight start marker, defaults to "<mark>"
+	StopSel           string   // Highlight stop marker, defaults to "</mark>"
+}
+
+// NewFullTextSearch creates a search over the given columns
+func NewFullTextSearch(dialect Dialect, columns ...string) *FullTextSearch {
+	return &FullTextSearch{
+		Dialect:  dialect,
+		Columns:  columns,
+		Language: "simple",
+		StartSel: "<mark>",
+		StopSel:  "</mark>",
+	}
+}
+
+// Fts5CreateTableSQL returns the DDL of an FTS5 table whose tokenizer removes diacritics
+func Fts5CreateTableSQL(table string, columns ...string) string {
+	return fmt.Sprintf("CREATE VIRTUAL TABLE IF NOT EXISTS %s USING fts5(%s, tokenize = 'unicode61 remove_diacritics 2')",
+		table, strings.Join(columns, ", "))
+}
+
+// NormalizeTerm trims the term and strips diacritics when the search is accent-insensitive
+func (s *FullTextSearch) NormalizeTerm(term string) string {
+	term = strings.Join(strings.Fields(term), " ")
+	if s.AccentInsensitive {
+		term = fxstring.RemoveAccents(term)
+	}
+	return term
+}
+
+// Match returns the WHERE predicate matching the term
+func (s *FullTextSearch) Match(term string) (string, []any) {
+	term = s.NormalizeTerm(term)
+	switch s.Dialect {
+	case DialectPostgres:
+		document, documentParams := s.tsVector()
+		return document + " @@ websearch_to_tsquery(?::regconfig, ?)", append(documentParams, s.Language, term)
+	case DialectSQLite:
+		return s.FtsTable + " MATCH ?", []any{fts5Query(term)}
+	default:
+		return "MATCH(" + strings.Join(s.Columns, ", ") + ") AGAINST (? IN NATURAL LANGUAGE MODE)", []any{term}
+	}
+}
+
+// Rank returns the relevance expression, higher is more relevant
+func (s *FullTextSearch) Rank(term string) (string, []any) {
+	term = s.NormalizeTerm(term)
+	switch s.Dialect {
+	case DialectPostgres:
+		document, documentParams := s.tsVector()
+		return "ts_rank(" + document + ", websearch_to_tsquery(?::regconfig, ?))", append(documentParams, s.Language, term)
+	case DialectSQLite:
+		// bm25 returns lower values for better matches
+		return "-bm25(" + s.FtsTable + ")", nil
+	default:
+		return "MATCH(" + strings.Join(s.Columns, ", ") + ") AGAINST (? IN NATURAL LANGUAGE MODE)", []any{term}
+	}
+}
+
+// Snippet returns an expression producing an excerpt of the matched text. The excerpt is raw column text:
+// HighlightItems must be applied to the results to escape it and insert the highlight markers.
+// The excerpt keeps its diacritics; on PostgreSQL, accent-insensitive highlighting needs a Language whose
+// dictionaries include unaccent. MySQL has no native equivalent; the raw column is highlighted by HighlightItems.
+func (s *FullTextSearch) Snippet(term string) (string, []any) {
+	term = s.NormalizeTerm(term)
+	column := s.highlightColumn()
+	switch s.Dialect {
+	case DialectPostgres:
+		options := fmt.Sprintf(`StartSel="%s", StopSel="%s", MaxFragments=2, MaxWords=30, MinWords=10`, snippetStart, snippetStop)
+		return "ts_headline(?::regconfig, " + column + ", websearch_to_tsquery(?::regconfig, ?), ?)",
+			[]any{s.Language, s.Language, term, options}
+	case DialectSQLite:
+		return "snippet(" + s.FtsTable + ", ?, ?, ?, ?, ?)", []any{-1, snippetStart, snippetStop, "...", 16}
+	default:
+		return column, nil
+	}
+}
+
+// Validate checks that the search is configured for its dialect
+func (s *FullTextSearch) Validate() error {
+	switch s.Dialect {
+	case DialectPostgres:
+		if s.Language == "" {
+			return errors.New("full text search: Language is required for PostgreSQL")
+		}
+	case DialectSQLite:
+		if s.FtsTable == "" {
+			return errors.New("full text search: FtsTable is required for SQLite")
+		}
+	case DialectMySQL:
+	default:
+		return fmt.Errorf("full text search: unsupported dialect %q", s.Dialect)
+	}
+	if len(s.Columns) == 0 {
+		return errors.New("full text search: at least one column is required")
+	}
+	return nil
+}
+
+// Apply adds the relevance, snippet and match predicate to the query. An empty term leaves the query untouched.
+// An invalid configuration fails the query at Build.
+func (s *FullTextSearch) Apply(b *QueryBuilder, term string) *QueryBuilder {
+	if strings.TrimSpace(term) == "" {
+		return b
+	}
+	if err := s.Validate(); err != nil {
+		if b.err == nil {
+			b.err = err
+		}
+		return b
+	}
+	rank, rankParams := s.Rank(term)
+	snippet, snippetParams := s.Snippet(term)
+	match, matchParams := s.Match(term)
+	return b.SelectExpr(rank+" AS "+SearchRankAlias, rankParams...).
+		SelectExpr(snippet+" AS "+SearchSnippetAlias, snippetParams...).
+		Where(match, matchParams...)
+}
+
+// HighlightItems HTML-escapes the snippet field of each item and highlights the matches with StartSel/StopSel:
+// the markers of the native snippet functions on PostgreSQL and SQLite, occurrences of the term words otherwise.
+func (s *FullTextSearch) HighlightItems(items []map[string]any, term string) {
+	words := strings.Fields(s.NormalizeTerm(term))
+	if len(words) == 0 {
+		return
+	}
+	native := s.Dialect == DialectPostgres || s.Dialect == DialectSQLite
+	field := fxstring.ToJsonCase(SearchSnippetAlias)
+	for _, item := range items {
+		text, ok := item[field].(string)
+		if !ok || text == "" {
+			continue
+		}
+		if native {
+			item[field] = s.renderSnippet(text)
+		} else {
+			item[field] = s.highlight(text, words)
+		}
+	}
+}
+
+// ExecuteSearchPaging applies the search to the query and returns the same paging shape as ExecuteJsonPaging.
+// When a term is given and no order is requested the items are sorted by relevance.
+func ExecuteSearchPaging(repository IGenericRepository, builder *QueryBuilder, search *FullTextSearch,
+	term string, pageable fxmodel.Pageable) (map[string]any, error) {
+	hasTerm := strings.TrimSpace(term) != ""
+	query, params, err := search.Apply(builder, term).Build()
+	if err != nil {
+		return nil, err
+	}
+	if hasTerm && pageable.Order == "" {
+		pageable.Order = RelevanceOrder
+	}
+	result, err := repository.ExecuteJsonPaging(query, pageable, params...)
+	if err != nil {
+		return nil, err
+	}
+	if hasTerm {
+		if items, ok := result["items"].([]map[string]any); ok {
+			search.HighlightItems(items, term)
+		}
+	}
+	return result, nil
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+func (s *FullTextSearch) tsVector() (string, []any) {
+	document := "concat_ws(chr(?), " + strings.Join(s.Columns, ", ") + ")"
+	if s.AccentInsensitive {
+		document = "unaccent(" + document + ")"
+	}
+	return "to_tsvector(?::regconfig, " + document + ")", []any{s.Language, 32}
+}
+
+func (s *FullTextSearch) highlightColumn() string {
+	if s.HighlightColumn != "" {
+		return s.HighlightColumn
+	}
+	if len(s.Columns) > 0 {
+		return s.Columns[0]
+	}
+	return "NULL"
+}
+
+func (s *FullTextSearch) highlight(text string, words []string) string {
+	// Match on the accent-free text so that "Da Nang" highlights "Đà Nẵng"
+	source := []rune(text)
+	folded := []rune(strings.ToLower(text))
+	if s.AccentInsensitive {
+		folded = []rune(strings.ToLower(fxstring.RemoveAccents(text)))
+	}
+	if len(folded) != len(source) {
+		folded = []rune(strings.ToLower(text))
+	}
+	patterns := make([]string, 0, len(words))
+	for _, word := range words {
+		patterns = append(patterns, regexp.QuoteMeta(strings.ToLower(word)))
+	}
+	matcher := regexp.MustCompile(strings.Join(patterns, "|"))
+	var sb strings.Builder
+	last := 0
+	foldedText := string(folded)
+	for _, loc := range matcher.FindAllStringIndex(foldedText, -1) {
+		start := len([]rune(foldedText[:loc[0]]))
+		end := len([]rune(foldedText[:loc[1]]))
+		sb.WriteString(html.EscapeString(string(source[last:start])))
+		sb.WriteString(s.StartSel)
+		sb.WriteString(html.EscapeString(string(source[start:end])))
+		sb.WriteString(s.StopSel)
+		last = end
+	}
+	sb.WriteString(html.EscapeString(string(source[last:])))
+	return sb.String()
+}
+
+// renderSnippet escapes a native snippet and replaces its markers with the highlight selectors
+func (s *FullTextSearch) renderSnippet(text string) string {
+	text = html.EscapeString(text)
+	return strings.NewReplacer(snippetStart, s.StartSel, snippetStop, s.StopSel).Replace(text)
+}
+
+// fts5Query quotes every word so that user input cannot use FTS5 query operators
+func fts5Query(term string) string {
+	words := strings.Fields(term)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
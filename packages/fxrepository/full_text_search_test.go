@@ -0,0 +1,89 @@
+package fxrepository
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFullTextSearchPostgresSQL(t *testing.T) {
+	search := NewFullTextSearch(DialectPostgres, "title", "body")
+	search.AccentInsensitive = true
+	const document = "to_tsvector(?::regconfig, unaccent(concat_ws(chr(?), title, body)))"
+
+	match, matchParams := search.Match(" Đà  Nẵng ")
+	if want := document + " @@ websearch_to_tsquery(?::regconfig, ?)"; match != want {
+		t.Errorf("Match = %q, want %q", match, want)
+	}
+	if want := []any{"simple", 32, "simple", "Da Nang"}; !reflect.DeepEqual(matchParams, want) {
+		t.Errorf("Match params = %v, want %v", matchParams, want)
+	}
+	if rank, _ := search.Rank("Đà Nẵng"); rank != "ts_rank("+document+", websearch_to_tsquery(?::regconfig, ?))" {
+		t.Errorf("Rank = %q", rank)
+	}
+
+	// The snippet is built from the original text, so its diacritics are kept
+	snippet, snippetParams := search.Snippet("Đà Nẵng")
+	if want := "ts_headline(?::regconfig, title, websearch_to_tsquery(?::regconfig, ?), ?)"; snippet != want {
+		t.Errorf("Snippet = %q, want %q", snippet, want)
+	}
+	if len(snippetParams) != 4 || snippetParams[2] != "Da Nang" {
+		t.Errorf("Snippet params = %v, want the normalized term third", snippetParams)
+	}
+}
+
+func TestFullTextSearchMySQLAndSQLiteSQL(t *testing.T) {
+	mysql := NewFullTextSearch(DialectMySQL, "title", "body")
+	if match, params := mysql.Match("go  sql"); match != "MATCH(title, body) AGAINST (? IN NATURAL LANGUAGE MODE)" || !reflect.DeepEqual(params, []any{"go sql"}) {
+		t.Errorf("MySQL Match = %q %v", match, params)
+	}
+	if snippet, params := mysql.Snippet("go"); snippet != "title" || params != nil {
+		t.Errorf("MySQL Snippet = %q %v, want the raw column", snippet, params)
+	}
+
+	sqlite := NewFullTextSearch(DialectSQLite, "title", "body")
+	sqlite.FtsTable = "articles_fts"
+	if match, params := sqlite.Match(`go "sql" OR`); match != "articles_fts MATCH ?" || !reflect.DeepEqual(params, []any{`"go" """sql""" "OR"`}) {
+		t.Errorf("SQLite Match = %q %v, want every word quoted", match, params)
+	}
+	if rank, _ := sqlite.Rank("go"); rank != "-bm25(articles_fts)" {
+		t.Errorf("SQLite Rank = %q", rank)
+	}
+}
+
+func TestFullTextSearchApply(t *testing.T) {
+	search := NewFullTextSearch(DialectMySQL, "title")
+	query, params, err := search.Apply(Select("id").From("articles"), "go").Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	want := "SELECT id, MATCH(title) AGAINST (? IN NATURAL LANGUAGE MODE) AS search_rank, title AS search_snippet " +
+		"FROM articles WHERE (MATCH(title) AGAINST (? IN NATURAL LANGUAGE MODE))"
+	if query != want || !reflect.DeepEqual(params, []any{"go", "go"}) {
+		t.Errorf("Build = %q %v, want %q [go go]", query, params, want)
+	}
+
+	if query, _, _ := search.Apply(Select("id").From("articles"), "  ").Build(); query != "SELECT id FROM articles" {
+		t.Errorf("an empty term changed the query to %q", query)
+	}
+	invalid := NewFullTextSearch(DialectSQLite, "title")
+	if _, _, err := invalid.Apply(Select("id").From("articles"), "go").Build(); err == nil {
+		t.Error("a SQLite search without FtsTable was accepted")
+	}
+}
+
+func TestHighlightItems(t *testing.T) {
+	native := NewFullTextSearch(DialectPostgres, "title")
+	items := []map[string]any{{"searchSnippet": "<b>" + snippetStart + "Go" + snippetStop + "</b>"}}
+	native.HighlightItems(items, "go")
+	if got := items[0]["searchSnippet"]; got != "&lt;b&gt;<mark>Go</mark>&lt;/b&gt;" {
+		t.Errorf("native snippet = %q", got)
+	}
+
+	search := NewFullTextSearch(DialectMySQL, "title")
+	search.AccentInsensitive = true
+	items = []map[string]any{{"searchSnippet": "Trip to Đà Nẵng & Huế"}}
+	search.HighlightItems(items, "da nang")
+	if got := items[0]["searchSnippet"]; got != "Trip to <mark>Đà</mark> <mark>Nẵng</mark> &amp; Huế" {
+		t.Errorf("highlighted snippet = %q", got)
+	}
+}
@@ -1,6 +1,7 @@
 package fxrepository
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"math"
@@ -29,18 +30,60 @@ type IGenericRepository interface {
 	Delete(model any, conditions ...any) (int64, error)
 	DeleteAll(models []any) (int64, error)
 }
+
+// IContextRepository is implemented by repositories that can be bound to a request context
+type IContextRepository interface {
+	IGenericRepository
+	WithContext(ctx context.Context) IGenericRepository
+}
+
+// WithContext binds the repository to the request context when it supports it (see IContextRepository),
+// otherwise returns it unchanged
+func WithContext(repository IGenericRepository, ctx context.Context) IGenericRepository {
+	if contextual, ok := repository.(IContextRepository); ok {
+		return contextual.WithContext(ctx)
+	}
+	return repository
+}
+
 type genericRepository struct {
-	db *gorm.DB
+	db      *gorm.DB
+	ctx     context.Context
+	masking *MaskingPolicy
+	history *HistoryRecorder
 }
 
-func NewGenericRepository(db *gorm.DB) IGenericRepository {
-	return &genericRepository{db: db}
+// RepositoryOption configures optional behaviour of the generic repository
+type RepositoryOption func(*genericRepository)
+
+// WithMaskingPolicy applies the given masking policy to every row returned by the Execute* mappers
+func WithMaskingPolicy(policy *MaskingPolicy) RepositoryOption {
+	return func(r *genericRepository) {
+		r.masking = policy
+	}
+}
+
+func NewGenericRepository(db *gorm.DB, options ...RepositoryOption) IGenericRepository {
+	repository := &genericRepository{db: db, ctx: context.Background()}
+	for _, option := range options {
+		option(repository)
+	}
+	return repository
 }
 
 func (this *genericRepository) GetDB() *gorm.DB {
 	return this.db
 }
 
+// WithContext returns a copy of the repository bound to the request context.
+// The context is propagated to gorm and used to resolve the caller's roles for data masking.
+func (this *genericRepository) WithContext(ctx context.Context) IGenericRepository {
+	clone := *this
+	clone.db = this.db.WithContext(ctx)
+	clone.ctx = ctx
+	return &clone
+}
+
 // For update, delete command
 func (this *genericRepository) ExecuteNonQuery(query string, params ...any) (int64, error) {
 	var result *gorm.DB
@@ -74,6 +117,10 @@ func (this *genericRepository) ExecuteJsonList(query string, params ...any) ([]m
 	if err != nil {
 		return nil, err
 	}
+	masker, err := this.newRowMasker(query, rows)
+	if err != nil {
+		return nil, err
+	}
 	for rows.Next() {
 		// Create a slice of interface{} to hold each column value
 		columnsData := make([]interface{}, len(columns))
@@ -85,6 +132,7 @@ func (this *genericRepository) ExecuteJsonList(query string, params ...any) ([]m
 		if ex := rows.Scan(columnPointers...); ex != nil {
 			return nil, ex
 		}
+		masker.apply(columnsData)
 		// Create a map to hold column names and their values
 		rowMap := make(map[string]interface{})
 		for i, col := range columns {
@@ -99,6 +147,7 @@ func (this *genericRepository) ExecuteJsonList(query string, params ...any) ([]m
 		// Add the map to the result slice
 		result = append(result, rowMap)
 	}
+	masker.audit(len(result))
 	return result, nil
 }
 
@@ -155,6 +204,10 @@ func (this *genericRepository) ExecuteKeyValueList(keyAlias string, valueAlias s
 	if len(columns) < 2 {
 		return nil, fmt.Errorf("query is required 2 selected columns")
 	}
+	masker, err := this.newRowMasker(query, rows)
+	if err != nil {
+		return nil, err
+	}
 	for rows.Next() {
 		// Create a slice of interface{} to hold each column value
 		columnsData := make([]interface{}, len(columns))
@@ -166,6 +219,7 @@ func (this *genericRepository) ExecuteKeyValueList(keyAlias string, valueAlias s
 		if ex := rows.Scan(columnPointers...); ex != nil {
 			return nil, ex
 		}
+		masker.apply(columnsData)
 		// Create a map to hold column names and their values
 		rowMap := make(map[string]interface{})
 		// Convert []byte to string to avoid base64 encoding in JSON
@@ -182,6 +236,7 @@ func (this *genericRepository) ExecuteKeyValueList(keyAlias string, valueAlias s
 		// Add the map to the result slice
 		result = append(result, rowMap)
 	}
+	masker.audit(len(result))
 	return result, nil
 }
 
@@ -201,6 +256,10 @@ func (this *genericRepository) ExecuteJsonObject(query string, params ...any) (m
 	if err != nil {
 		return nil, err
 	}
+	masker, err := this.newRowMasker(query, rows)
+	if err != nil {
+		return nil, err
+	}
 	rowMap := make(map[string]interface{})
 	if rows.Next() {
 		// Create a slice of interface{} to hold each column value
@@ -213,6 +272,8 @@ func (this *genericRepository) ExecuteJsonObject(query string, params ...any) (m
 		if ex := rows.Scan(columnPointers...); ex != nil {
 			return nil, ex
 		}
+		masker.apply(columnsData)
+		masker.audit(1)
 		for i, col := range columns {
 			jsonField := fxstring.ToJsonCase(col)
 			// Convert []byte to string to avoid base64 encoding in JSON
@@ -242,6 +303,10 @@ func (this *genericRepository) ExecuteStringList(query string, params ...any) ([
 	if err != nil {
 		return nil, err
 	}
+	masker, err := this.newRowMasker(query, rows)
+	if err != nil {
+		return nil, err
+	}
 	var result []string
 	for rows.Next() {
 		// Create a slice of interface{} to hold each column value
@@ -254,15 +319,23 @@ func (this *genericRepository) ExecuteStringList(query string, params ...any) ([
 		if ex := rows.Scan(columnPointers...); ex != nil {
 			return nil, ex
 		}
+		masker.apply(columnsData)
 		strValue := fxstring.ToString(columnsData[0])
 		if strValue != "" {
 			result = append(result, strValue)
 		}
 	}
+	masker.audit(len(result))
 	return result, nil
 }
 
 func (this *genericRepository) ExecuteScalar(query string, params ...any) (any, error) {
+	return this.executeScalar(query, true, params...)
+}
+
+// executeScalar returns the first column of the first row. Masking is only applied when masked is set:
+// a masked value is a string, which the numeric and boolean conversions cannot parse.
+func (this *genericRepository) executeScalar(query string, masked bool, params ...any) (any, error) {
 	var rows *sql.Rows
 	var err error
 	if len(params) == 0 {
@@ -280,6 +353,13 @@ func (this *genericRepository) ExecuteScalar(query string, params ...any) (any,
 	if err != nil {
 		return nil, err
 	}
+	var masker *rowMasker
+	if masked {
+		masker, err = this.newRowMasker(query, rows)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if rows.Next() {
 		// Create a slice of interface{} to hold each column value
 		columnsData := make([]interface{}, len(columns))
@@ -291,6 +371,8 @@ func (this *genericRepository) ExecuteScalar(query string, params ...any) (any,
 		if ex := rows.Scan(columnPointers...); ex != nil {
 			return nil, ex
 		}
+		masker.apply(columnsData)
+		masker.audit(1)
 		// Return the first column value in the first row
 		result = columnsData[0]
 		return result, nil
@@ -302,9 +384,9 @@ func (this *genericRepository) ExecuteScalarAsBool(query string, params ...any)
 	var value any
 	var err error
 	if len(params) == 0 {
-		value, err = this.ExecuteScalar(query)
+		value, err = this.executeScalar(query, false)
 	} else {
-		value, err = this.ExecuteScalar(query, params...)
+		value, err = this.executeScalar(query, false, params...)
 	}
 	if err != nil {
 		return false, err
@@ -334,9 +416,9 @@ func (this *genericRepository) ExecuteScalarAsLong(query string, params ...any)
 	var value any
 	var err error
 	if len(params) == 0 {
-		value, err = this.ExecuteScalar(query)
+		value, err = this.executeScalar(query, false)
 	} else {
-		value, err = this.ExecuteScalar(query, params...)
+		value, err = this.executeScalar(query, false, params...)
 	}
 	if err != nil {
 		return 0, err
@@ -378,6 +460,16 @@ func (this *genericRepository) ExecuteScalarAsString(query string, params ...any
 }
 
 func (this *genericRepository) Create(model any) (any, error) {
+	if s := this.history.tracked(model); s != nil {
+		_, err := this.history.capture(this.ctx, this.db, s, HistoryCreate, model, nil, func(tx *gorm.DB) (int64, error) {
+			result := tx.Create(model)
+			return result.RowsAffected, result.Error
+		})
+		if err != nil {
+			return nil, err
+		}
+		return model, nil
+	}
 	result := this.db.Create(model)
 	if result.Error != nil {
 		return nil, result.Error
@@ -386,6 +478,16 @@ func (this *genericRepository) Create(model any) (any, error) {
 }
 
 func (this *genericRepository) Save(record any) (any, error) {
+	if s := this.history.tracked(record); s != nil {
+		_, err := this.history.capture(this.ctx, this.db, s, HistoryUpdate, record, nil, func(tx *gorm.DB) (int64, error) {
+			result := tx.Save(record)
+			return result.RowsAffected, result.Error
+		})
+		if err != nil {
+			return nil, err
+		}
+		return record, nil
+	}
 	// Use db.Save for upsert behavior (Insert or Update if record already exists)
 	result := this.db.Save(record)
 	if result.Error != nil {
@@ -395,6 +497,12 @@ func (this *genericRepository) Save(record any) (any, error) {
 }
 
 func (this *genericRepository) Delete(model any, conditions ...any) (int64, error) {
+	if s := this.history.tracked(model); s != nil {
+		return this.history.capture(this.ctx, this.db, s, HistoryDelete, model, conditions, func(tx *gorm.DB) (int64, error) {
+			result := tx.Delete(model, conditions...)
+			return result.RowsAffected, result.Error
+		})
+	}
 	result := this.db.Delete(model, conditions...)
 	if result.Error != nil {
 		return 0, result.Error
@@ -405,11 +513,11 @@ func (this *genericRepository) Delete(model any, conditions ...any) (int64, erro
 func (this *genericRepository) DeleteAll(models []any) (int64, error) {
 	var rowsEffected int64
 	for _, model := range models {
-		result := this.db.Delete(model)
-		if result.Error != nil {
-			return 0, fmt.Errorf("failed to delete model %v: %w", model, result.Error)
+		affected, err := this.Delete(model)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete model %v: %w", model, err)
 		}
-		rowsEffected += result.RowsAffected
+		rowsEffected += affected
 	}
 	return rowsEffected, nil
 }
@@ -0,0 +1,284 @@
+package fxrepository
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Dialect identifies the placeholder style of a SQL database
+type Dialect string
+
+const (
+	DialectPostgres  Dialect = "postgres"
+	DialectMySQL     Dialect = "mysql"
+	DialectSQLite    Dialect = "sqlite"
+	DialectSQLServer Dialect = "sqlserver"
+)
+
+// DialectOf returns the dialect of the gorm connection
+func DialectOf(db *gorm.DB) Dialect {
+	if db == nil || db.Dialector == nil {
+		return DialectMySQL
+	}
+	return Dialect(db.Dialector.Name())
+}
+
+// QueryBuilder composes SELECT statements from parameterized fragments.
+// Build returns SQL with "?" placeholders that can be passed to any Execute* method of IGenericRepository,
+// including ExecuteJsonPaging (which appends its own ORDER BY / LIMIT clauses).
+type QueryBuilder struct {
+	columns []string
+	from    string
+	joins   []string
+	where   []string
+	groupBy []string
+	having  []string
+	orderBy string
+
+	selectParams []any
+	joinParams   []any
+	whereParams  []any
+	havingParams []any
+	err          error
+}
+
+// Select starts a new query selecting the given columns ("*" when empty)
+func Select(columns ...string) *QueryBuilder {
+	b := &QueryBuilder{}
+	for _, column := range columns {
+		b.checkFragment(column, 0)
+	}
+	b.columns = columns
+	return b
+}
+
+// SelectExpr adds a parameterized select expression, e.g. SelectExpr("ts_rank(doc, query) AS rank")
+func (b *QueryBuilder) SelectExpr(expression string, params ...any) *QueryBuilder {
+	if b.checkFragment(expression, len(params)) {
+		b.columns = append(b.columns, expression)
+		b.selectParams = append(b.selectParams, params...)
+	}
+	return b
+}
+
+// From sets the table (or aliased table) to select from
+func (b *QueryBuilder) From(table string) *QueryBuilder {
+	b.checkFragment(table, 0)
+	b.from = table
+	return b
+}
+
+// Join adds an INNER JOIN, e.g. Join("roles r ON r.id = u.role_id")
+func (b *QueryBuilder) Join(join string, params ...any) *QueryBuilder {
+	return b.addJoin("INNER JOIN", join, params)
+}
+
+// LeftJoin adds a LEFT JOIN
+func (b *QueryBuilder) LeftJoin(join string, params ...any) *QueryBuilder {
+	return b.addJoin("LEFT JOIN", join, params)
+}
+
+// Where adds a condition combined with AND, e.g. Where("u.status = ?", status)
+func (b *QueryBuilder) Where(condition string, params ...any) *QueryBuilder {
+	if b.checkFragment(condition, len(params)) {
+		b.where = append(b.where, "("+condition+")")
+		b.whereParams = append(b.whereParams, params...)
+	}
+	return b
+}
+
+// AndIf adds the condition only when include is true
+func (b *QueryBuilder) AndIf(include bool, condition string, params ...any) *QueryBuilder {
+	if !include {
+		return b
+	}
+	return b.Where(condition, params...)
+}
+
+// WhereIn adds "column IN (?, ?, ...)" for the given values. An empty list matches no rows.
+func (b *QueryBuilder) WhereIn(column string, values ...any) *QueryBuilder {
+	if len(values) == 0 {
+		if b.err == nil {
+			b.where = append(b.where, "(1 = 0)")
+		}
+		return b
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+	return b.Where(column+" IN ("+placeholders+")", values...)
+}
+
+// GroupBy sets the GROUP BY columns
+func (b *QueryBuilder) GroupBy(columns ...string) *QueryBuilder {
+	for _, column := range columns {
+		b.checkFragment(column, 0)
+	}
+	b.groupBy = append(b.groupBy, columns...)
+	return b
+}
+
+// Having adds a HAVING condition combined with AND
+func (b *QueryBuilder) Having(condition string, params ...any) *QueryBuilder {
+	if b.checkFragment(condition, len(params)) {
+		b.having = append(b.having, "("+condition+")")
+		b.havingParams = append(b.havingParams, params...)
+	}
+	return b
+}
+
+// OrderBy sets the ORDER BY clause. Leave empty when the query is passed to ExecuteJsonPaging.
+func (b *QueryBuilder) OrderBy(order string) *QueryBuilder {
+	b.checkFragment(order, 0)
+	b.orderBy = order
+	return b
+}
+
+// Build returns the SQL statement with "?" placeholders and its parameters in order
+func (b *QueryBuilder) Build() (string, []any, error) {
+	if b.err != nil {
+		return "", nil, b.err
+	}
+	if b.from == "" {
+		return "", nil, fmt.Errorf("query builder: FROM clause is required")
+	}
+	var sb strings.Builder
+	sb.WriteString("SELECT ")
+	if len(b.columns) == 0 {
+		sb.WriteString("*")
+	} else {
+		sb.WriteString(strings.Join(b.columns, ", "))
+	}
+	sb.WriteString(" FROM ")
+	sb.WriteString(b.from)
+	for _, join := range b.joins {
+		sb.WriteString(" ")
+		sb.WriteString(join)
+	}
+	if len(b.where) > 0 {
+		sb.WriteString(" WHERE ")
+		sb.WriteString(strings.Join(b.where, " AND "))
+	}
+	if len(b.groupBy) > 0 {
+		sb.WriteString(" GROUP BY ")
+		sb.WriteString(strings.Join(b.groupBy, ", "))
+	}
+	if len(b.having) > 0 {
+		sb.WriteString(" HAVING ")
+		sb.WriteString(strings.Join(b.having, " AND "))
+	}
+	if b.orderBy != "" {
+		sb.WriteString(" ORDER BY ")
+		sb.WriteString(b.orderBy)
+	}
+	params := make([]any, 0, len(b.selectParams)+len(b.joinParams)+len(b.whereParams)+len(b.havingParams))
+	params = append(params, b.selectParams...)
+	params = append(params, b.joinParams...)
+	params = append(params, b.whereParams...)
+	params = append(params, b.havingParams...)
+	return sb.String(), params, nil
+}
+
+// BuildFor returns the SQL statement rebound to the placeholder style of the dialect,
+// for use with database/sql directly instead of gorm
+func (b *QueryBuilder) BuildFor(dialect Dialect) (string, []any, error) {
+	query, params, err := b.Build()
+	if err != nil {
+		return "", nil, err
+	}
+	return Rebind(dialect, query), params, nil
+}
+
+// Rebind converts "?" placeholders to the placeholder style of the dialect ($1 for PostgreSQL, @p1 for SQL Server)
+func Rebind(dialect Dialect, query string) string {
+	var prefix string
+	switch dialect {
+	case DialectPostgres:
+		prefix = "$"
+	case DialectSQLServer:
+		prefix = "@p"
+	default:
+		return query
+	}
+	var sb strings.Builder
+	index := 0
+	for _, ch := range query {
+		if ch == '?' {
+			index++
+			sb.WriteString(prefix)
+			sb.WriteString(strconv.Itoa(index))
+			continue
+		}
+		sb.WriteRune(ch)
+	}
+	return sb.String()
+}
+
+// ----------------------------------------------------------------------------------------
+// Private functions
+// ----------------------------------------------------------------------------------------
+func (b *QueryBuilder) addJoin(kind string, join string, params []any) *QueryBuilder {
+	if b.checkFragment(join, len(params)) {
+		b.joins = append(b.joins, kind+" "+join)
+		b.joinParams = append(b.joinParams, params...)
+	}
+	return b
+}
+
+// checkFragment requires every string value of a fragment to be passed as a parameter: string literals,
+// statement separators and comments are rejected, and the number of "?" placeholders must match the
+// number of parameters. Quoted identifiers ("name", `name`, [name]) and numeric literals are allowed.
+func (b *QueryBuilder) checkFragment(fragment string, paramCount int) bool {
+	if b.err != nil {
+		return false
+	}
+	if strings.TrimSpace(fragment) == "" {
+		b.err = fmt.Errorf("query builder: empty SQL fragment")
+		return false
+	}
+	placeholders, err := scanFragment(fragment)
+	if err != nil {
+		b.err = fmt.Errorf("query builder: fragment %q %v, pass values as parameters instead", fragment, err)
+		return false
+	}
+	if placeholders != paramCount {
+		b.err = fmt.Errorf("query builder: fragment %q has %d placeholders but %d parameters", fragment, placeholders, paramCount)
+		return false
+	}
+	return true
+}
+
+// scanFragment counts the placeholders of a fragment, skipping quoted identifiers, and reports string literals,
+// separators and comments. Numeric literals such as COUNT(1) or DECIMAL(10,2) cannot inject anything.
+func scanFragment(fragment string) (int, error) {
+	placeholders := 0
+	runes := []rune(fragment)
+	for i := 0; i < len(runes); i++ {
+		ch := runes[i]
+		switch {
+		case ch == '"' || ch == '`' || ch == '[':
+			closing := ch
+			if ch == '[' {
+				closing = ']'
+			}
+			end := i + 1
+			for end < len(runes) && runes[end] != closing {
+				end++
+			}
+			if end == len(runes) {
+				return 0, fmt.Errorf("has an unterminated quoted identifier")
+			}
+			i = end
+		case ch == '\'':
+			return 0, fmt.Errorf("contains a string literal")
+		case ch == ';':
+			return 0, fmt.Errorf("contains a statement separator")
+		case ch == '-' && i+1 < len(runes) && runes[i+1] == '-', ch == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			return 0, fmt.Errorf("contains a comment")
+		case ch == '?':
+			placeholders++
+		}
+	}
+	return placeholders, nil
+}
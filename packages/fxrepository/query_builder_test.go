@@ -0,0 +1,62 @@
+package fxrepository
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQueryBuilderAllowsNumericLiterals(t *testing.T) {
+	query, params, err := Select("u.role_id", "COUNT(1) AS total", "CAST(AVG(u.score) AS DECIMAL(10,2)) AS score").
+		From("users u").
+		Where("u.deleted = 0").
+		Where("u.status = ?", "active").
+		GroupBy("u.role_id").
+		Having("COUNT(*) > 1").
+		Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	want := "SELECT u.role_id, COUNT(1) AS total, CAST(AVG(u.score) AS DECIMAL(10,2)) AS score FROM users u " +
+		"WHERE (u.deleted = 0) AND (u.status = ?) GROUP BY u.role_id HAVING (COUNT(*) > 1)"
+	if query != want {
+		t.Errorf("query = %q, want %q", query, want)
+	}
+	if !reflect.DeepEqual(params, []any{"active"}) {
+		t.Errorf("params = %v, want [active]", params)
+	}
+}
+
+func TestQueryBuilderRejectsInlineValues(t *testing.T) {
+	fragments := []string{
+		"name = 'admin'",
+		"id = ?; DROP TABLE users",
+		"id = ? -- trailing",
+		"id = ? /* comment */",
+		`"unterminated = ?`,
+	}
+	for _, fragment := range fragments {
+		if _, _, err := Select().From("users").Where(fragment, 1).Build(); err == nil {
+			t.Errorf("Where(%q) was accepted", fragment)
+		}
+	}
+	if _, _, err := Select().From("users").Where("id = ? AND role = ?", 1).Build(); err == nil {
+		t.Error("a placeholder without a parameter was accepted")
+	}
+	if _, _, err := Select().From("users").Where(`"select" = ?`, 1).Build(); err != nil {
+		t.Errorf("a quoted identifier was rejected: %v", err)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	query := "SELECT * FROM users WHERE id = ? AND role = ?"
+	tests := map[Dialect]string{
+		DialectPostgres:  "SELECT * FROM users WHERE id = $1 AND role = $2",
+		DialectSQLServer: "SELECT * FROM users WHERE id = @p1 AND role = @p2",
+		DialectMySQL:     query,
+	}
+	for dialect, want := range tests {
+		if got := Rebind(dialect, query); got != want {
+			t.Errorf("Rebind(%s) = %q, want %q", dialect, got, want)
+		}
+	}
+}
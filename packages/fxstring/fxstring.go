@@ -9,6 +9,8 @@ import (
 	"strings"
 	"time"
 	"unicode"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 func IsEmpty(value any) bool {
@@ -112,3 +114,24 @@ func StringToJsonArray(s string) ([]map[string]any, error) {
 	}
 	return result, nil
 }
+
+// RemoveAccents strips diacritics so that accented text (e.g. Vietnamese) can be matched
+// accent-insensitively: "Đà Nẵng" becomes "Da Nang"
+func RemoveAccents(s string) string {
+	decomposed := norm.NFD.String(s)
+	var sb strings.Builder
+	sb.Grow(len(decomposed))
+	for _, r := range decomposed {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r == 'đ':
+			sb.WriteRune('d')
+		case r == 'Đ':
+			sb.WriteRune('D')
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return norm.NFC.String(sb.String())
+}